@@ -0,0 +1,479 @@
+package containers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"miniker/images"
+	"miniker/networks"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 构建文件中的一条指令
+type buildInstruction struct {
+	Cmd  string
+	Args string
+	Line int
+}
+
+func (inst *buildInstruction) String() string {
+	return inst.Cmd + " " + inst.Args
+}
+
+// 镜像构建器，每条指令在上一步的镜像上生成新的镜像
+type builder struct {
+	contextDir string
+	network    string
+	driver     StorageDriver
+	img        *images.Image
+	config     *images.ImageConfig
+}
+
+// 解析构建文件，支持注释和以'\'结尾的续行
+func parseBuildFile(fileName string) ([]*buildInstruction, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var instructions []*buildInstruction
+	var current string
+	start := 0
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if current == "" && (line == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+		if current == "" {
+			start = lineNo
+		}
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		current += line
+
+		fields := strings.SplitN(current, " ", 2)
+		inst := &buildInstruction{Cmd: strings.ToUpper(fields[0]), Line: start}
+		if len(fields) == 2 {
+			inst.Args = strings.TrimSpace(fields[1])
+		}
+		switch inst.Cmd {
+		case "FROM", "RUN", "COPY", "ENV", "WORKDIR", "CMD":
+		default:
+			return nil, fmt.Errorf("line %d: unknown instruction %s", start, inst.Cmd)
+		}
+		if inst.Args == "" {
+			return nil, fmt.Errorf("line %d: %s requires arguments", start, inst.Cmd)
+		}
+		instructions = append(instructions, inst)
+		current = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(instructions) == 0 || instructions[0].Cmd != "FROM" {
+		return nil, fmt.Errorf("build file must start with FROM")
+	}
+	return instructions, nil
+}
+
+// 根据构建文件构建镜像，成功后使用tag为镜像命名
+func buildImage(buildFile, contextDir, tag, network, storageDriver string) error {
+	instructions, err := parseBuildFile(buildFile)
+	if err != nil {
+		return err
+	}
+	driver, err := getStorageDriver(storageDriver)
+	if err != nil {
+		return err
+	}
+	b := &builder{
+		contextDir: contextDir,
+		network:    network,
+		driver:     driver,
+	}
+
+	for i, inst := range instructions {
+		fmt.Fprintf(os.Stdout, "Step %d/%d : %s\n", i+1, len(instructions), inst)
+		if err := b.step(inst); err != nil {
+			return fmt.Errorf("line %d: %v", inst.Line, err)
+		}
+		fmt.Fprintf(os.Stdout, " ---> %s\n", b.img.Id)
+	}
+
+	if tag != "" {
+		if err := images.TagImage(b.img.Id, tag); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stdout, "Successfully built %s\n", b.img.Id)
+	return nil
+}
+
+// 执行一条指令，指令和父镜像都相同时使用缓存
+func (b *builder) step(inst *buildInstruction) error {
+	if inst.Cmd == "FROM" {
+		img, err := images.PrepareImage(inst.Args)
+		if err != nil {
+			return err
+		}
+		b.img = img
+		b.config = img.Config.Copy()
+		return nil
+	}
+
+	keyParts := []string{inst.Cmd, inst.Args}
+	if inst.Cmd == "COPY" {
+		// COPY的缓存还需要考虑源文件的内容
+		srcs, _, err := b.copySources(inst.Args)
+		if err != nil {
+			return err
+		}
+		digest, err := contentDigest(b.contextDir, srcs)
+		if err != nil {
+			return err
+		}
+		keyParts = append(keyParts, digest)
+	}
+	key := images.BuildCacheKey(b.img.Id, keyParts...)
+	if cached, ok := images.LookupBuildCache(key); ok {
+		fmt.Fprintln(os.Stdout, " ---> Using cache")
+		b.img = cached
+		b.config = cached.Config.Copy()
+		return nil
+	}
+
+	var img *images.Image
+	var err error
+	switch inst.Cmd {
+	case "RUN":
+		img, err = b.run(parseCommand(inst.Args))
+	case "COPY":
+		img, err = b.copy(inst.Args)
+	case "ENV":
+		env, parseErr := parseEnvInstruction(inst.Args)
+		if parseErr != nil {
+			return parseErr
+		}
+		b.config.Env = mergeEnv(b.config.Env, env)
+		img, err = images.DeriveImage(b.img, b.config)
+	case "WORKDIR":
+		b.config.WorkingDir = b.resolvePath(inst.Args)
+		img, err = images.DeriveImage(b.img, b.config)
+	case "CMD":
+		b.config.Cmd = parseCommand(inst.Args)
+		img, err = images.DeriveImage(b.img, b.config)
+	}
+	if err != nil {
+		return err
+	}
+	b.img = img
+	b.config = img.Config.Copy()
+	return images.StoreBuildCache(key, img.Id)
+}
+
+// 解析命令，支持json数组形式和shell形式
+func parseCommand(args string) []string {
+	var cmds []string
+	if strings.HasPrefix(args, "[") && json.Unmarshal([]byte(args), &cmds) == nil {
+		return cmds
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// 解析ENV指令，支持 KEY=VALUE ... 和 KEY VALUE 两种形式
+func parseEnvInstruction(args string) ([]string, error) {
+	if !strings.Contains(strings.Fields(args)[0], "=") {
+		fields := strings.SplitN(args, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("ENV %s requires a value", args)
+		}
+		return []string{fields[0] + "=" + strings.TrimSpace(fields[1])}, nil
+	}
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENV %s, %v", args, err)
+	}
+	var env []string
+	for _, kv := range words {
+		if strings.Index(kv, "=") <= 0 {
+			return nil, fmt.Errorf("invalid ENV %s", kv)
+		}
+		env = append(env, kv)
+	}
+	return env, nil
+}
+
+// 按空白拆分参数，引号中的空白不拆分。单引号中的内容原样保留，其他位置的反斜杠转义下一个字符
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// 将容器内的路径转换为绝对路径，相对路径基于当前的WORKDIR
+func (b *builder) resolvePath(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	wd := b.config.WorkingDir
+	if wd == "" {
+		wd = "/"
+	}
+	return path.Join(wd, p)
+}
+
+// 在临时容器中执行RUN指令，并将容器的改动提交为新的镜像层
+func (b *builder) run(cmds []string) (*images.Image, error) {
+	containerId := generateId()
+	containerName := "build-" + containerId
+	if err := images.AcquireLayers(b.img.Layers, containerId); err != nil {
+		return nil, err
+	}
+	defer images.ReleaseLayers(b.img.Layers, containerId)
+	defer deleteWorkSpace(containerName, "", b.driver)
+
+	parent, pipe := NewParentProcess(true, "", containerName, b.img.Layers, b.driver)
+	if parent == nil {
+		return nil, fmt.Errorf("failed to create build container")
+	}
+	parent.Stdin = nil
+	if err := parent.Start(); err != nil {
+		pipe.Close()
+		return nil, err
+	}
+	var ip, nameserver net.IP
+	if b.network != "" {
+		endpoint, err := networks.Connect(b.network, containerName, nil, nil, parent.Process.Pid)
+		if err != nil {
+			logger.Sugar().Error(err)
+		} else {
+			ip = endpoint.IPAddress
+			nameserver = endpoint.NetWork.IpRange.IP
+			defer networks.Disconnect(b.network, containerName, 0)
+		}
+	}
+	defer deleteContainerInfo(containerName)
+	hostMounts, err := setUpHostFiles(containerName, containerId, ip, nameserver)
+	if err != nil {
+		pipe.Close()
+		parent.Wait()
+		return nil, err
+	}
+	err = pipe.send(&InitConfig{
+		Args:     cmds,
+		Env:      containerEnv(b.config.Env, nil),
+		Cwd:      b.config.WorkingDir,
+		User:     b.config.User,
+		Hostname: containerId,
+		Mounts:   append(defaultMounts(), hostMounts...),
+	})
+	if err != nil {
+		parent.Wait()
+		return nil, fmt.Errorf("RUN %v: %v", cmds, err)
+	}
+	if err := parent.Wait(); err != nil {
+		return nil, fmt.Errorf("RUN %v: %v", cmds, err)
+	}
+	return b.commit(containerName)
+}
+
+// 将构建容器的改动提交为新的镜像层
+func (b *builder) commit(containerName string) (*images.Image, error) {
+	diffDir, cleanup, err := b.driver.Diff(containerName, imageLayerDirs(b.img.Layers), "")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return images.CommitLayer(diffDir, "", b.img.Id, b.img.Layers, b.config)
+}
+
+// 解析COPY指令，返回构建上下文中的源文件和容器中的目标路径
+func (b *builder) copySources(args string) ([]string, string, error) {
+	var fields []string
+	if !strings.HasPrefix(args, "[") || json.Unmarshal([]byte(args), &fields) != nil {
+		fields = strings.Fields(args)
+	}
+	if len(fields) < 2 {
+		return nil, "", fmt.Errorf("COPY requires at least one source and a destination")
+	}
+
+	var srcs []string
+	for _, pattern := range fields[:len(fields)-1] {
+		// 源文件不能位于构建上下文之外
+		matches, err := filepath.Glob(path.Join(b.contextDir, path.Clean("/"+pattern)))
+		if err != nil {
+			return nil, "", err
+		}
+		if len(matches) == 0 {
+			return nil, "", fmt.Errorf("COPY source %s not found", pattern)
+		}
+		srcs = append(srcs, matches...)
+	}
+	return srcs, fields[len(fields)-1], nil
+}
+
+// 将构建上下文中的文件复制到镜像中，并提交为新的镜像层
+func (b *builder) copy(args string) (*images.Image, error) {
+	srcs, dest, err := b.copySources(args)
+	if err != nil {
+		return nil, err
+	}
+	toDir := strings.HasSuffix(dest, "/") || len(srcs) > 1
+	dest = b.resolvePath(dest)
+
+	containerId := generateId()
+	containerName := "build-" + containerId
+	if err := images.AcquireLayers(b.img.Layers, containerId); err != nil {
+		return nil, err
+	}
+	defer images.ReleaseLayers(b.img.Layers, containerId)
+	defer deleteWorkSpace(containerName, "", b.driver)
+	if err := b.driver.Mount(containerName, imageLayerDirs(b.img.Layers)); err != nil {
+		return nil, err
+	}
+
+	rootfs := mntUrlOf(containerName)
+	for _, src := range srcs {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		target := dest
+		if !info.IsDir() && toDir {
+			target = path.Join(dest, path.Base(src))
+		}
+		hostTarget, err := securePath(rootfs, target)
+		if err != nil {
+			return nil, err
+		}
+
+		cpSrc := src
+		if info.IsDir() {
+			// 目录只复制其中的内容
+			if err := os.MkdirAll(hostTarget, 0755); err != nil {
+				return nil, err
+			}
+			cpSrc = strings.TrimSuffix(src, "/") + "/."
+		} else if err := os.MkdirAll(path.Dir(hostTarget), 0755); err != nil {
+			return nil, err
+		}
+		if output, err := exec.Command("cp", "-a", cpSrc, hostTarget).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("copy %s err %v %s", src, err, output)
+		}
+	}
+	return b.commit(containerName)
+}
+
+// 获取容器内路径在宿主机上的位置，路径中的符号链接在rootfs内解析，防止访问rootfs之外的文件
+func securePath(rootfs, p string) (string, error) {
+	current := "/"
+	links := 0
+	parts := strings.Split(path.Clean("/"+p), "/")
+	for i := 0; i < len(parts); i++ {
+		if parts[i] == "" {
+			continue
+		}
+		next := path.Join(current, parts[i])
+		info, err := os.Lstat(path.Join(rootfs, next))
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		link, err := os.Readlink(path.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+		}
+		// 将链接目标替换到剩余路径之前重新解析
+		if !path.IsAbs(link) {
+			link = path.Join(current, link)
+		}
+		parts = append(strings.Split(path.Clean(link), "/"), parts[i+1:]...)
+		current = "/"
+		i = -1
+	}
+	return path.Join(rootfs, current), nil
+}
+
+// 计算源文件的内容摘要，用作COPY指令的缓存键
+func contentDigest(contextDir string, srcs []string) (string, error) {
+	hash := sha256.New()
+	for _, src := range srcs {
+		err := filepath.Walk(src, func(fileName string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(contextDir, fileName)
+			fmt.Fprintf(hash, "%s %o\n", rel, info.Mode())
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			file, err := os.Open(fileName)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(hash, file)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
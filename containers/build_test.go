@@ -0,0 +1,47 @@
+package containers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvInstruction(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    []string
+		wantErr bool
+	}{
+		{args: "A=1", want: []string{"A=1"}},
+		{args: "A=1 B=2", want: []string{"A=1", "B=2"}},
+		{args: `MSG="hello world"`, want: []string{"MSG=hello world"}},
+		{args: `A="x y" B=1`, want: []string{"A=x y", "B=1"}},
+		{args: `A=x\ y`, want: []string{"A=x y"}},
+		{args: `A="say \"hi\""`, want: []string{`A=say "hi"`}},
+		{args: `A='single "quoted" \n'`, want: []string{`A=single "quoted" \n`}},
+		{args: `A= B=""`, want: []string{"A=", "B="}},
+		{args: "A=1\tB=2", want: []string{"A=1", "B=2"}},
+		{args: "A=a=b", want: []string{"A=a=b"}},
+		{args: "MY_NAME John Doe", want: []string{"MY_NAME=John Doe"}},
+		{args: "A", wantErr: true},
+		{args: "A=1 B", wantErr: true},
+		{args: "A=1 =2", wantErr: true},
+		{args: `A="unterminated`, wantErr: true},
+		{args: `A=1\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEnvInstruction(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseEnvInstruction(%q) = %q, want error", tt.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseEnvInstruction(%q) err %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseEnvInstruction(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package containers
 import (
 	"errors"
 	"fmt"
+	"miniker/networks"
 	"miniker/subsystems"
 	"os"
+	"path"
 
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
@@ -16,10 +18,19 @@ func init() {
 	logger, _ = zap.NewProduction()
 }
 
+// 全局参数，指定容器使用的存储驱动
+func NewStorageDriverFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "storage-driver",
+		Usage: "Storage driver to use (overlay, vfs)",
+		Value: DefaultStorageDriver,
+	}
+}
+
 func NewRunCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "run",
-		Usage: `Create a container. miniker run -it [command]`,
+		Usage: `Create a container. miniker run -it image [command]`,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:  "it",
@@ -55,12 +66,41 @@ func NewRunCommand() *cli.Command {
 			},
 			&cli.StringSliceFlag{
 				Name:  "p",
-				Usage: "Publish a container's ports to the host",
+				Usage: "Publish a container's ports to the host, format: [hostIP:]hostPort[-range]:containerPort[-range][/tcp|udp|sctp]",
+			},
+			&cli.StringSliceFlag{
+				Name:  "e",
+				Usage: "Set environment variables",
+			},
+			&cli.StringSliceFlag{
+				Name:  "env-file",
+				Usage: "Read in a file of environment variables",
+			},
+			// 资源限制通过init消息的rlimits字段传给容器进程
+			&cli.StringSliceFlag{
+				Name:  "ulimit",
+				Usage: "Ulimit options, format: name=soft[:hard], name is one of core, cpu, data, fsize, nofile, stack, as, memlock, nproc",
+			},
+			&cli.StringFlag{
+				Name:  "w",
+				Usage: "Working directory inside the container",
+			},
+			&cli.StringFlag{
+				Name:  "u",
+				Usage: "Username or UID (format: <name|uid>[:<group|gid>])",
+			},
+			&cli.StringFlag{
+				Name:  "hostname",
+				Usage: "Container host name (default: container ID)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "network-alias",
+				Usage: "Add network-scoped alias for the container",
 			},
 		},
 		Action: func(ctx *cli.Context) error {
 			if ctx.Args().Len() < 1 {
-				return errors.New("wrong container command")
+				return errors.New("please input image name")
 			}
 			createTty := ctx.Bool("it")
 			detach := ctx.Bool("d")
@@ -81,9 +121,39 @@ func NewRunCommand() *cli.Command {
 			cmds := ctx.Args().Slice()[1:]
 			containerName := ctx.String("name")
 			networkName := ctx.String("network")
-			portMapping := ctx.StringSlice("p")
-			Run(createTty, cmds, subsystemConfig, volume, containerName, imageName, networkName, portMapping)
-			return nil
+			// 在容器启动前检查端口映射
+			portMapping, err := networks.ValidatePortMappings(ctx.StringSlice("p"))
+			if err != nil {
+				return err
+			}
+			if len(portMapping) > 0 && networkName == "" {
+				return errors.New("publishing ports requires --network")
+			}
+			// 环境变量文件中的变量可以被-e参数覆盖
+			var envs []string
+			for _, envFile := range ctx.StringSlice("env-file") {
+				fileEnvs, err := parseEnvFile(envFile)
+				if err != nil {
+					return err
+				}
+				envs = mergeEnv(envs, fileEnvs)
+			}
+			flagEnvs, err := parseEnvFlags(ctx.StringSlice("e"))
+			if err != nil {
+				return err
+			}
+			envs = mergeEnv(envs, flagEnvs)
+			rlimits, err := parseUlimits(ctx.StringSlice("ulimit"))
+			if err != nil {
+				return err
+			}
+			// 全局参数，可以通过ctx的继承链获取
+			storageDriver := ctx.String("storage-driver")
+			workDir := ctx.String("w")
+			if workDir != "" && !path.IsAbs(workDir) {
+				return errors.New("the working directory must be an absolute path")
+			}
+			return Run(createTty, cmds, envs, rlimits, subsystemConfig, volume, containerName, imageName, networkName, storageDriver, workDir, ctx.String("u"), ctx.String("hostname"), ctx.StringSlice("network-alias"), portMapping)
 		},
 	}
 }
@@ -129,6 +199,19 @@ func NewPsCommand() *cli.Command {
 	}
 }
 
+func NewInspectCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "inspect",
+		Usage: "Display detailed information on a container. miniker inspect [containerName]",
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input container name")
+			}
+			return inspectContainer(ctx.Args().Get(0))
+		},
+	}
+}
+
 func NewLogsCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "logs",
@@ -149,6 +232,16 @@ func NewExecCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "exec",
 		Usage: "Run a command in a running container",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "w",
+				Usage: "Working directory inside the container",
+			},
+			&cli.StringFlag{
+				Name:  "u",
+				Usage: "Username or UID (format: <name|uid>[:<group|gid>])",
+			},
+		},
 		Action: func(ctx *cli.Context) error {
 			if os.Getenv(ENV_EXEC_PID) != "" {
 				logger.Sugar().Infof("enter containers, pid %d", os.Getpid())
@@ -159,7 +252,11 @@ func NewExecCommand() *cli.Command {
 			}
 			containerName := ctx.Args().Get(0)
 			commands := ctx.Args().Slice()[1:]
-			execCommands(containerName, commands)
+			workDir := ctx.String("w")
+			if workDir != "" && !path.IsAbs(workDir) {
+				return errors.New("the working directory must be an absolute path")
+			}
+			execCommands(containerName, commands, workDir, ctx.String("u"))
 			return nil
 		},
 	}
@@ -194,3 +291,70 @@ func NewRemoveCommand() *cli.Command {
 		},
 	}
 }
+
+func NewBuildCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "build",
+		Usage: "Build an image from a build file. miniker build -t name [context]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "Name of the build file (default: context/" + DefaultBuildFile + ")",
+			},
+			&cli.StringFlag{
+				Name:    "tag",
+				Aliases: []string{"t"},
+				Usage:   "Name and optionally a tag in the 'name:tag' format",
+			},
+			&cli.StringFlag{
+				Name:  "network",
+				Usage: "Connect RUN instructions to a network",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			contextDir := "."
+			if ctx.Args().Len() > 0 {
+				contextDir = ctx.Args().Get(0)
+			}
+			buildFile := ctx.String("file")
+			if buildFile == "" {
+				buildFile = path.Join(contextDir, DefaultBuildFile)
+			}
+			logger.Sugar().Infof("Build image from %s", buildFile)
+			return buildImage(buildFile, contextDir, ctx.String("tag"), ctx.String("network"), ctx.String("storage-driver"))
+		},
+	}
+}
+
+func NewNetworkConnectCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "connect",
+		Usage: "Connect a running container to a network. miniker network connect network container",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "alias",
+				Usage: "Add network-scoped alias for the container",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 2 {
+				return errors.New("please input network name and container name")
+			}
+			return connectNetwork(ctx.Args().Get(0), ctx.Args().Get(1), ctx.StringSlice("alias"))
+		},
+	}
+}
+
+func NewNetworkDisconnectCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "disconnect",
+		Usage: "Disconnect a running container from a network. miniker network disconnect network container",
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 2 {
+				return errors.New("please input network name and container name")
+			}
+			return disconnectNetwork(ctx.Args().Get(0), ctx.Args().Get(1))
+		},
+	}
+}
@@ -1,24 +1,35 @@
 package containers
 
 import (
-	"fmt"
-	"os"
-	"os/exec"
-	"path"
+	"miniker/images"
 )
 
-// 提取镜像，存储格式为.tar
+// 将容器的读写层提交为新的镜像层，新镜像以容器所用的镜像为父镜像
 func commitImage(containerName, imageName string) {
-	cur, err := os.Getwd()
+	containerInfo := getContainerInfo(containerName)
+	if containerInfo == nil {
+		logger.Sugar().Errorf("Cannot get container info by name %s", containerName)
+		return
+	}
+
+	// 使用创建容器时的存储驱动获取容器的改动
+	driver, err := getStorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		logger.Sugar().Error(err)
+		return
+	}
+	diffDir, cleanup, err := driver.Diff(containerName, imageLayerDirs(containerInfo.Layers), containerInfo.Volume)
 	if err != nil {
-		logger.Sugar().Errorf("get pwd err %v", err)
+		logger.Sugar().Errorf("get diff of container %s err %v", containerName, err)
 		return
 	}
+	defer cleanup()
 
-	fileName := path.Join(cur, "resources", imageName+".tar")
-	mntUrl := fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
-	logger.Sugar().Infof("tar %s to %s", mntUrl, fileName)
-	if _, err := exec.Command("tar", "-cf", fileName, "-C", mntUrl, ".").CombinedOutput(); err != nil {
-		logger.Sugar().Errorf("error tar image %s, %v", imageName, err)
+	// 新镜像沿用容器的运行配置
+	img, err := images.CommitLayer(diffDir, imageName, containerInfo.ImageId, containerInfo.Layers, containerInfo.Config)
+	if err != nil {
+		logger.Sugar().Errorf("error commit image %s, %v", imageName, err)
+		return
 	}
+	logger.Sugar().Infof("commit container %s to image %s %s", containerName, imageName, img.Id)
 }
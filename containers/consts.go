@@ -1,15 +1,27 @@
 package containers
 
 var (
-	RUNNING             string = "running"
-	STOPED              string = "stoped"
-	EXIT                string = "exit"
-	DefaultInfoLocation string = "/var/run/miniker/info/%s/"
-	ConfigName          string = "config.json"
-	LogName             string = "container.log"
-	ENV_EXEC_PID        string = "miniker_pid"
-	ENV_EXEC_CMD        string = "miniker_cmd"
-	ImageUrl            string = "%s/miniker/images/%s/"
-	WriteLayer          string = "%s/miniker/write/%s/"
-	MntUrl              string = "%s/miniker/mnt/%s/"
+	RUNNING              string = "running"
+	STOPED               string = "stoped"
+	EXIT                 string = "exit"
+	DefaultInfoLocation  string = "/var/run/miniker/info/%s/"
+	ConfigName           string = "config.json"
+	LogName              string = "container.log"
+	ENV_EXEC_PID         string = "miniker_pid"
+	ENV_EXEC_ARGC        string = "miniker_argc"
+	ENV_EXEC_ARG         string = "miniker_arg_"
+	ENV_EXEC_UID         string = "miniker_uid"
+	ENV_EXEC_GID         string = "miniker_gid"
+	ENV_EXEC_GROUPS      string = "miniker_groups"
+	ENV_EXEC_CWD         string = "miniker_cwd"
+	ImageUrl             string = "%s/miniker/images/%s/"
+	WriteLayer           string = "%s/miniker/write/%s/"
+	MntUrl               string = "%s/miniker/mnt/%s/"
+	WorkLayer            string = "%s/miniker/work/%s/"
+	DefaultStorageDriver string = "overlay"
+	CgroupRoot           string = "miniker"
+	DefaultBuildFile     string = "Minikerfile"
 )
+
+// 以root运行时，容器的user namespace映射的uid和gid数量
+const idMappingSize = 65536
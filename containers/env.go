@@ -0,0 +1,97 @@
+package containers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 容器未设置PATH时使用的默认值
+const DefaultPathEnv = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// 解析-e参数，KEY=VALUE直接使用，只有KEY时从宿主机的环境变量中取值
+func parseEnvFlags(envs []string) ([]string, error) {
+	var res []string
+	for _, kv := range envs {
+		kv = strings.TrimSpace(kv)
+		key := strings.SplitN(kv, "=", 2)[0]
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid environment variable %q", kv)
+		}
+		if !strings.Contains(kv, "=") {
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			kv = key + "=" + value
+		}
+		res = append(res, kv)
+	}
+	return res, nil
+}
+
+// 读取环境变量文件，每行一个变量，忽略空行和以'#'开头的注释
+func parseEnvFile(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	envs, err := parseEnvFlags(lines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return envs, nil
+}
+
+// 合并环境变量，后者覆盖前者中的同名变量
+func mergeEnv(base []string, overrides []string) []string {
+	merged := append([]string(nil), base...)
+	for _, kv := range overrides {
+		key := strings.SplitN(kv, "=", 2)[0]
+		replaced := false
+		for i := range merged {
+			if strings.SplitN(merged[i], "=", 2)[0] == key {
+				merged[i] = kv
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// 生成容器的环境变量：镜像配置中的变量被用户指定的变量覆盖，并保证PATH存在
+func containerEnv(imageEnv []string, userEnv []string) []string {
+	env := mergeEnv(imageEnv, userEnv)
+	if lookupEnv(env, "PATH") == "" {
+		env = append([]string{DefaultPathEnv}, env...)
+	}
+	return env
+}
+
+// 从环境变量列表中查找变量的值
+func lookupEnv(env []string, key string) string {
+	for _, kv := range env {
+		if fields := strings.SplitN(kv, "=", 2); len(fields) == 2 && fields[0] == key {
+			return fields[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package containers
+
+import (
+	"bufio"
+	"fmt"
+	"miniker/networks"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+var (
+	HostsName      string = "hosts"
+	ResolvConfName string = "resolv.conf"
+	// 宿主机的resolv.conf
+	HostResolvConf string = "/etc/resolv.conf"
+	// 宿主机没有可用的dns服务器时使用的默认值
+	DefaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+)
+
+// 在容器的信息目录中生成hosts和resolv.conf，返回将其绑定挂载到容器中的配置。
+// nameserver为容器所在网络的dns服务地址，容器未连接网络时为nil
+func setUpHostFiles(containerName, hostname string, ip, nameserver net.IP) ([]InitMount, error) {
+	var ips []net.IP
+	if ip != nil {
+		ips = append(ips, ip)
+	}
+	if err := writeHostFiles(containerName, hostname, ips, nameserver); err != nil {
+		return nil, err
+	}
+	dirUrl := fmt.Sprintf(DefaultInfoLocation, containerName)
+	return []InitMount{
+		{Source: path.Join(dirUrl, HostsName), Target: "/etc/hosts", Type: "bind", Flags: syscall.MS_BIND},
+		{Source: path.Join(dirUrl, ResolvConfName), Target: "/etc/resolv.conf", Type: "bind", Flags: syscall.MS_BIND},
+	}, nil
+}
+
+// 写入容器的hosts和resolv.conf。文件已绑定挂载到容器中，
+// 因此只能原地改写，不能替换为新文件
+func writeHostFiles(containerName, hostname string, ips []net.IP, nameserver net.IP) error {
+	dirUrl := fmt.Sprintf(DefaultInfoLocation, containerName)
+	if err := os.MkdirAll(dirUrl, 0622); err != nil {
+		return err
+	}
+
+	hostsFile := path.Join(dirUrl, HostsName)
+	if err := os.WriteFile(hostsFile, []byte(buildHosts(hostname, ips)), 0644); err != nil {
+		return err
+	}
+	resolvConf, err := buildResolvConf(HostResolvConf, nameserver)
+	if err != nil {
+		return err
+	}
+	resolvFile := path.Join(dirUrl, ResolvConfName)
+	return os.WriteFile(resolvFile, []byte(resolvConf), 0644)
+}
+
+// 容器连接或断开网络后，根据其所有的网络端点更新hosts和resolv.conf
+func refreshHostFiles(containerInfo *ContainerInfo) error {
+	var ips []net.IP
+	var nameserver net.IP
+	for _, ep := range networks.ContainerEndpoints(containerInfo.Name) {
+		ips = append(ips, ep.IPAddress)
+		if nameserver == nil {
+			nameserver = ep.NetWork.IpRange.IP
+		}
+	}
+	return writeHostFiles(containerInfo.Name, containerInfo.Hostname, ips, nameserver)
+}
+
+// 生成hosts文件的内容，容器连接网络时将主机名解析为容器的ip
+func buildHosts(hostname string, ips []net.IP) string {
+	var b strings.Builder
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	for _, ip := range ips {
+		fmt.Fprintf(&b, "%s\t%s\n", ip, hostname)
+	}
+	return b.String()
+}
+
+// 根据宿主机的resolv.conf生成容器的resolv.conf。指定了nameserver时只使用网络的dns服务，
+// 否则使用宿主机的dns服务器。容器位于独立的network namespace，
+// 无法访问宿主机回环地址上的dns服务器，因此去掉这些服务器
+func buildResolvConf(hostResolvConf string, nameserver net.IP) (string, error) {
+	var nameservers, others []string
+	file, err := os.Open(hostResolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+				continue
+			}
+			if fields[0] != "nameserver" {
+				others = append(others, line)
+				continue
+			}
+			if len(fields) > 1 {
+				if ip := net.ParseIP(fields[1]); ip != nil && !ip.IsLoopback() {
+					nameservers = append(nameservers, fields[1])
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+	}
+	if nameserver != nil {
+		nameservers = []string{nameserver.String()}
+	}
+	if len(nameservers) == 0 {
+		nameservers = DefaultNameservers
+	}
+
+	var b strings.Builder
+	for _, nameserver := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", nameserver)
+	}
+	for _, line := range others {
+		b.WriteString(line + "\n")
+	}
+	return b.String(), nil
+}
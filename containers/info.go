@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"miniker/images"
+	"miniker/networks"
 	"os"
 	"strconv"
 	"strings"
@@ -21,18 +23,29 @@ type ContainerInfo struct {
 	Status      string   `json:"status"`
 	Volume      string   `json:"volume"`
 	PortMapping []string `json:"portMapping"`
+	CgroupPath  string   `json:"cgroupPath"`
+	// 创建容器所用的镜像和存储驱动
+	Image         string   `json:"image"`
+	ImageId       string   `json:"imageId"`
+	Layers        []string `json:"layers"`
+	StorageDriver string   `json:"storageDriver"`
+	// 容器的运行配置，由镜像配置和run的参数合并得到，commit时写入新镜像
+	Config *images.ImageConfig `json:"config"`
+	// 容器进程的环境变量
+	Env []string `json:"env"`
+	// 容器的主机名
+	Hostname string `json:"hostname"`
 }
 
-func recordContainerInfo(pid int, containerName string, cmds []string) string {
-	cInfo := &ContainerInfo{}
+// 记录容器信息，cInfo中需要预先填好Id、Name等创建时确定的字段
+func recordContainerInfo(pid int, cInfo *ContainerInfo, cmds []string) string {
 	cInfo.Pid = strconv.Itoa(pid)
 	logger.Sugar().Infof("Pid %d", os.Getpid())
-	cInfo.Id = generateId()
 	cInfo.CreateTime = time.Now().Format("2006-01-02 15:04:05")
-	if containerName == "" {
-		containerName = cInfo.Id
+	if cInfo.Name == "" {
+		cInfo.Name = cInfo.Id
 	}
-	cInfo.Name = containerName
+	containerName := cInfo.Name
 	cInfo.Command = strings.Join(cmds, " ")
 	cInfo.Status = RUNNING
 
@@ -147,17 +160,35 @@ func printContainers(containerInfos []*ContainerInfo) {
 		if maxLen["status"] < len(info.Status) {
 			maxLen["status"] = len(info.Status)
 		}
+		if maxLen["ports"] < len(networks.PublishedPorts(info.PortMapping)) {
+			maxLen["ports"] = len(networks.PublishedPorts(info.PortMapping))
+		}
 	}
 	infoFormat := "%-" + strconv.Itoa(maxLen["pid"]) + "s\t" +
 		"%-" + strconv.Itoa(maxLen["id"]) + "s\t" +
 		"%-" + strconv.Itoa(maxLen["name"]) + "s\t" +
 		"%-" + strconv.Itoa(maxLen["status"]) + "s\t" +
 		"%-" + strconv.Itoa(maxLen["ct"]) + "s\t" +
+		"%-" + strconv.Itoa(maxLen["ports"]) + "s\t" +
 		"%-" + strconv.Itoa(maxLen["cmd"]) + "s\n"
-	fmt.Printf(infoFormat, "Pid", "Id", "Name", "Status", "CreateTime", "Cmd")
+	fmt.Printf(infoFormat, "Pid", "Id", "Name", "Status", "CreateTime", "Ports", "Cmd")
 	for _, info := range containerInfos {
-		fmt.Printf(infoFormat, info.Pid, info.Id, info.Name, info.Status, info.CreateTime, info.Command)
+		fmt.Printf(infoFormat, info.Pid, info.Id, info.Name, info.Status, info.CreateTime, networks.PublishedPorts(info.PortMapping), info.Command)
+	}
+}
+
+// 以json格式打印容器信息
+func inspectContainer(containerName string) error {
+	cInfo := getContainerInfo(containerName)
+	if cInfo == nil {
+		return fmt.Errorf("no such container %s", containerName)
+	}
+	b, err := json.MarshalIndent(cInfo, "", "    ")
+	if err != nil {
+		return err
 	}
+	fmt.Fprintln(os.Stdout, string(b))
+	return nil
 }
 
 func updateContainerInfo(containerInfo *ContainerInfo) {
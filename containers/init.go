@@ -2,7 +2,6 @@ package containers
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
@@ -11,45 +10,139 @@ import (
 )
 
 func RunContainerInitProcess() error {
+	errPipe := openInitErrorPipe()
+	defer errPipe.Close()
+
+	err := initContainer()
+	if err != nil {
+		// 将错误报告给父进程，父进程据此判断容器是否启动成功
+		logger.Sugar().Error(err)
+		errPipe.WriteString(err.Error())
+	}
+	return err
+}
+
+// 根据父进程发送的配置初始化容器，最后exec用户命令
+func initContainer() error {
 	// 从管道读取父进程的消息
-	commands := readParentCommands()
-	if len(commands) == 0 {
-		return fmt.Errorf("wrong commands")
+	cfg, err := readInitConfig()
+	if err != nil {
+		return err
 	}
-	logger.Sugar().Infof("Commands is %v\n", commands)
+	logger.Sugar().Infof("Commands is %v\n", cfg.Args)
 
-	err := setUpMount()
+	if err := setUpMount(cfg.Mounts); err != nil {
+		return err
+	}
+	if cfg.Hostname != "" {
+		if err := syscall.Sethostname([]byte(cfg.Hostname)); err != nil {
+			return fmt.Errorf("set hostname %s err %v", cfg.Hostname, err)
+		}
+	}
+	for _, rlimit := range cfg.Rlimits {
+		limit := &syscall.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}
+		if err := syscall.Setrlimit(rlimit.Type, limit); err != nil {
+			return fmt.Errorf("set rlimit %d err %v", rlimit.Type, err)
+		}
+	}
+	if cfg.Cwd != "" {
+		if err := os.MkdirAll(cfg.Cwd, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Chdir(cfg.Cwd); err != nil {
+			return fmt.Errorf("chdir %s err %v", cfg.Cwd, err)
+		}
+	}
+	// 用户名在容器的/etc/passwd中解析，未指定用户时使用root
+	user, err := resolveUser("/", cfg.User)
 	if err != nil {
 		return err
 	}
+	if lookupEnv(cfg.Env, "HOME") == "" {
+		cfg.Env = append(cfg.Env, "HOME="+user.Home)
+	}
+	if cfg.User != "" {
+		if err := setUser(user); err != nil {
+			return err
+		}
+	}
+
+	// 使用容器的环境变量替换从父进程继承的环境，命令按容器的PATH查找
+	os.Clearenv()
+	for _, kv := range cfg.Env {
+		if fields := strings.SplitN(kv, "=", 2); len(fields) == 2 {
+			os.Setenv(fields[0], fields[1])
+		}
+	}
 	// 查找命令的绝对路径
-	path, err := exec.LookPath(commands[0])
+	path, err := exec.LookPath(cfg.Args[0])
 	if err != nil {
-		// logger.Sugar().Error(err.Error())
 		return err
 	}
 	logger.Sugar().Infof("find path %s\n", path)
 
-	if err := syscall.Exec(path, commands, os.Environ()); err != nil {
-		logger.Sugar().Error(err.Error())
+	if err := syscall.Exec(path, cfg.Args, cfg.Env); err != nil {
+		return fmt.Errorf("exec %s err %v", path, err)
+	}
+	return nil
+}
+
+// 切换到指定的用户，附加组需要在切换用户之前设置
+func setUser(user *execUser) error {
+	if err := setGroups(user.Groups); err != nil {
 		return err
 	}
+	if err := syscall.Setgid(user.Gid); err != nil {
+		return fmt.Errorf("setgid %d err %v", user.Gid, err)
+	}
+	if err := syscall.Setuid(user.Uid); err != nil {
+		return fmt.Errorf("setuid %d err %v", user.Uid, err)
+	}
 	return nil
 }
 
-func readParentCommands() []string {
-	pipe := os.NewFile(uintptr(3), "pipe")
-	res, err := ioutil.ReadAll(pipe)
+// 设置附加组。user namespace中setgroups被禁止时，只有附加组不需要变化才能继续
+func setGroups(groups []int) error {
+	if !setgroupsDenied() {
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("setgroups %v err %v", groups, err)
+		}
+		return nil
+	}
+	current, err := syscall.Getgroups()
 	if err != nil {
-		logger.Sugar().Error(err)
+		return fmt.Errorf("getgroups err %v", err)
+	}
+	if len(groups) == 0 || sameGroups(groups, current) {
 		return nil
 	}
-	defer pipe.Close()
+	return fmt.Errorf("supplementary groups %v need root, setgroups is denied in the user namespace", groups)
+}
+
+// /proc/self/setgroups为deny时，当前user namespace中不能调用setgroups
+func setgroupsDenied() bool {
+	content, err := os.ReadFile("/proc/self/setgroups")
+	return err == nil && strings.TrimSpace(string(content)) == "deny"
+}
 
-	return strings.Split(string(res), " ")
+// 两组gid是否相同，不考虑顺序和重复
+func sameGroups(a, b []int) bool {
+	set := map[int]bool{}
+	for _, gid := range a {
+		set[gid] = true
+	}
+	other := map[int]bool{}
+	for _, gid := range b {
+		if !set[gid] {
+			return false
+		}
+		other[gid] = true
+	}
+	return len(set) == len(other)
 }
 
-func setUpMount() error {
+// 执行父进程指定的挂载，然后切换rootfs
+func setUpMount(mounts []InitMount) error {
 	pwd, err := os.Getwd()
 	if err != nil {
 		logger.Sugar().Errorf("Error get current location. %v", err)
@@ -58,21 +151,19 @@ func setUpMount() error {
 
 	logger.Sugar().Infof("Current location is %s", pwd)
 
-	// Todo：挂载/proc和tmpfs的步骤需要在privotRoot之前执行，否则可能会提示无权限
-
-	// 挂载/proc
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	err = syscall.Mount("proc", path.Join(pwd, "proc"), "proc", uintptr(defaultMountFlags), "")
-	if err != nil {
-		logger.Sugar().Errorf("error mount proc. %v", err)
-		return err
-	}
-
-	// 挂载tmpfs
-	err = syscall.Mount("tempfs", path.Join(pwd, "dev"), "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
-	if err != nil {
-		logger.Sugar().Errorf("error mount /dev. %v", err)
-		return err
+	// 挂载需要在privotRoot之前执行，否则可能会提示无权限
+	for _, m := range mounts {
+		// 挂载点中的符号链接在rootfs内解析，防止挂载到rootfs之外
+		target, err := securePath(pwd, m.Target)
+		if err != nil {
+			return err
+		}
+		if err := createMountPoint(m, target); err != nil {
+			return fmt.Errorf("create mount point %s err %v", m.Target, err)
+		}
+		if err := syscall.Mount(m.Source, target, m.Type, m.Flags, m.Data); err != nil {
+			return fmt.Errorf("mount %s on %s err %v", m.Source, m.Target, err)
+		}
 	}
 
 	err = pivotRoot(pwd)
@@ -83,6 +174,23 @@ func setUpMount() error {
 	return nil
 }
 
+// 创建挂载点，绑定挂载文件时挂载点也需要是文件
+func createMountPoint(m InitMount, target string) error {
+	if m.Flags&syscall.MS_BIND != 0 {
+		if info, err := os.Stat(m.Source); err == nil && !info.IsDir() {
+			if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			return file.Close()
+		}
+	}
+	return os.MkdirAll(target, 0755)
+}
+
 // 修改rootfs
 func pivotRoot(newRoot string) error {
 	// 重新挂载newRoot
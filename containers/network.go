@@ -0,0 +1,47 @@
+package containers
+
+import (
+	"fmt"
+	"miniker/networks"
+	"strconv"
+)
+
+// 获取运行中容器的信息和进程号
+func runningContainer(containerName string) (*ContainerInfo, int, error) {
+	containerInfo := getContainerInfo(containerName)
+	if containerInfo == nil {
+		return nil, 0, fmt.Errorf("no such container %s", containerName)
+	}
+	if containerInfo.Status != RUNNING {
+		return nil, 0, fmt.Errorf("container %s is not running", containerName)
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return nil, 0, fmt.Errorf("convert string %s err %v", containerInfo.Pid, err)
+	}
+	return containerInfo, pid, nil
+}
+
+// 将运行中的容器连接到网络，容器可以同时连接多个网络
+func connectNetwork(networkName, containerName string, aliases []string) error {
+	containerInfo, pid, err := runningContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if _, err := networks.Connect(networkName, containerInfo.Name, aliases, nil, pid); err != nil {
+		return err
+	}
+	return refreshHostFiles(containerInfo)
+}
+
+// 断开运行中的容器与网络的连接
+func disconnectNetwork(networkName, containerName string) error {
+	containerInfo, pid, err := runningContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if err := networks.Disconnect(networkName, containerInfo.Name, pid); err != nil {
+		return err
+	}
+	return refreshHostFiles(containerInfo)
+}
@@ -9,6 +9,8 @@ package containers
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/wait.h>
 __attribute__((constructor)) void enter_namespace(void) {
 	char *mydocker_pid;
 	mydocker_pid = getenv("miniker_pid");
@@ -18,14 +20,46 @@ __attribute__((constructor)) void enter_namespace(void) {
 		fprintf(stdout, "missing mydocker_pid env skip nsenter");
 		return;
 	}
-	char *mydocker_cmd;
-	mydocker_cmd = getenv("miniker_cmd");
-	if (mydocker_cmd) {
-		//fprintf(stdout, "got mydocker_cmd=%s\n", mydocker_cmd);
+	char *mydocker_argc;
+	mydocker_argc = getenv("miniker_argc");
+	if (mydocker_argc) {
+		//fprintf(stdout, "got mydocker_argc=%s\n", mydocker_argc);
 	} else {
-		//fprintf(stdout, "missing mydocker_cmd env skip nsenter");
+		//fprintf(stdout, "missing mydocker_argc env skip nsenter");
 		return;
 	}
+	// 命令的每个参数保存在单独的环境变量miniker_arg_<i>中，参数中的空格不会被拆分
+	int argc = atoi(mydocker_argc);
+	if (argc <= 0) {
+		fprintf(stderr, "invalid miniker_argc %s\n", mydocker_argc);
+		exit(1);
+	}
+	char **argv = calloc(argc + 1, sizeof(char *));
+	char name[64];
+	for (int j = 0; j < argc; j++) {
+		snprintf(name, sizeof(name), "miniker_arg_%d", j);
+		char *arg = getenv(name);
+		if (!arg) {
+			fprintf(stderr, "missing %s\n", name);
+			exit(1);
+		}
+		argv[j] = strdup(arg);
+		unsetenv(name);
+	}
+	// 复制miniker内部使用的环境变量后将其删除，容器中执行的命令只看到容器的环境变量
+	mydocker_pid = strdup(mydocker_pid);
+	char *cwd = getenv("miniker_cwd");
+	char *groups = getenv("miniker_groups");
+	char *gid = getenv("miniker_gid");
+	char *uid = getenv("miniker_uid");
+	cwd = cwd ? strdup(cwd) : NULL;
+	groups = groups ? strdup(groups) : NULL;
+	gid = gid ? strdup(gid) : NULL;
+	uid = uid ? strdup(uid) : NULL;
+	char *internal[] = { "miniker_pid", "miniker_argc", "miniker_cwd", "miniker_groups", "miniker_gid", "miniker_uid" };
+	for (int j = 0; j < 6; j++) {
+		unsetenv(internal[j]);
+	}
 	int i;
 	char nspath[1024];
 	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
@@ -39,36 +73,119 @@ __attribute__((constructor)) void enter_namespace(void) {
 		}
 		close(fd);
 	}
-	int res = system(mydocker_cmd);
-	exit(0);
-	return;
+	// 进入mnt namespace后根目录已是容器的rootfs，再切换工作目录和用户
+	if (cwd && chdir(cwd) == -1) {
+		fprintf(stderr, "chdir %s failed: %s\n", cwd, strerror(errno));
+		exit(1);
+	}
+	if (groups) {
+		gid_t list[64];
+		int n = 0;
+		char *save = NULL;
+		for (char *g = strtok_r(groups, ",", &save); g && n < 64; g = strtok_r(NULL, ",", &save)) {
+			list[n++] = atoi(g);
+		}
+		if (setgroups(n, list) == -1) {
+			fprintf(stderr, "setgroups failed: %s\n", strerror(errno));
+			exit(1);
+		}
+	}
+	if (gid && setgid(atoi(gid)) == -1) {
+		fprintf(stderr, "setgid %s failed: %s\n", gid, strerror(errno));
+		exit(1);
+	}
+	if (uid && setuid(atoi(uid)) == -1) {
+		fprintf(stderr, "setuid %s failed: %s\n", uid, strerror(errno));
+		exit(1);
+	}
+	// 加入pid namespace只对子进程生效，在子进程中执行命令，并以命令的退出码退出
+	pid_t child = fork();
+	if (child == -1) {
+		fprintf(stderr, "fork failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child == 0) {
+		execvp(argv[0], argv);
+		fprintf(stderr, "exec %s failed: %s\n", argv[0], strerror(errno));
+		_exit(127);
+	}
+	int status;
+	while (waitpid(child, &status, 0) == -1) {
+		if (errno != EINTR) {
+			exit(1);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 */
 import "C"
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
-func execCommands(containerName string, commands []string) {
+func execCommands(containerName string, commands []string, workDir, user string) {
 	containerInfo := getContainerInfo(containerName)
 	if containerInfo == nil {
 		logger.Sugar().Errorf("Cannot get container info for name %s", containerName)
 		return
 	}
 
-	cmdStr := strings.Join(commands, " ")
 	logger.Sugar().Infof("container pid %s", containerInfo.Pid)
-	logger.Sugar().Infof("command %s", cmdStr)
+	logger.Sugar().Infof("command %q", commands)
+
+	// 未指定时使用容器创建时的工作目录和用户
+	if containerInfo.Config != nil {
+		if workDir == "" {
+			workDir = containerInfo.Config.WorkingDir
+		}
+		if user == "" {
+			user = containerInfo.Config.User
+		}
+	}
+	// 用户名在容器的/etc/passwd中解析
+	execUser, err := resolveUser(mntUrlOf(containerInfo.Name), user)
+	if err != nil {
+		logger.Sugar().Errorf("Exec container %s error %v", containerName, err)
+		return
+	}
 
 	cmd := exec.Command("/proc/self/exe", "exec")
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	os.Setenv(ENV_EXEC_PID, containerInfo.Pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
+	// 在容器中执行的命令使用容器的环境变量
+	env := containerEnv(containerInfo.Env, nil)
+	if lookupEnv(env, "HOME") == "" {
+		env = append(env, "HOME="+execUser.Home)
+	}
+	// 命令的参数逐个传递，由nsenter直接exec，不经过shell
+	env = append(env, ENV_EXEC_PID+"="+containerInfo.Pid, ENV_EXEC_ARGC+"="+strconv.Itoa(len(commands)))
+	for i, arg := range commands {
+		env = append(env, fmt.Sprintf("%s%d=%s", ENV_EXEC_ARG, i, arg))
+	}
+	if workDir != "" {
+		env = append(env, ENV_EXEC_CWD+"="+workDir)
+	}
+	if user != "" {
+		var groups []string
+		for _, gid := range execUser.Groups {
+			groups = append(groups, strconv.Itoa(gid))
+		}
+		env = append(env,
+			ENV_EXEC_UID+"="+strconv.Itoa(execUser.Uid),
+			ENV_EXEC_GID+"="+strconv.Itoa(execUser.Gid),
+			ENV_EXEC_GROUPS+"="+strings.Join(groups, ","),
+		)
+	}
+	cmd.Env = env
 
 	if err := cmd.Run(); err != nil {
 		logger.Sugar().Errorf("Exec container %s error %v", containerName, err)
@@ -0,0 +1,80 @@
+package containers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// 基于overlayfs的存储驱动
+// lowerdir为镜像的只读层，upperdir为容器的读写层，workdir为overlayfs的工作目录
+type OverlayDriver struct{}
+
+func (o *OverlayDriver) Name() string {
+	return "overlay"
+}
+
+func (o *OverlayDriver) Mount(containerName string, lowerDirs []string) error {
+	if len(lowerDirs) == 0 {
+		return fmt.Errorf("overlay needs at least one lower dir")
+	}
+
+	writeUrl := writeUrlOf(containerName)
+	workUrl := fmt.Sprintf(WorkLayer, os.Getenv("HOME"), containerName)
+	mntUrl := mntUrlOf(containerName)
+	for _, dir := range []string{writeUrl, workUrl, mntUrl} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			logger.Sugar().Errorf("error mkdir %s. %v", dir, err)
+			return err
+		}
+	}
+
+	// 挂载点已经存在overlay时不再重复挂载
+	if mounted, _ := isMountPoint(mntUrl); mounted {
+		return nil
+	}
+
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowerDirs, ":"), writeUrl, workUrl)
+	if err := syscall.Mount("overlay", mntUrl, "overlay", 0, options); err != nil {
+		logger.Sugar().Errorf("error mount overlay %s. %v", options, err)
+		return err
+	}
+	return nil
+}
+
+func (o *OverlayDriver) Unmount(containerName string) error {
+	mntUrl := mntUrlOf(containerName)
+	if mounted, _ := isMountPoint(mntUrl); !mounted {
+		return nil
+	}
+	if err := syscall.Unmount(mntUrl, syscall.MNT_DETACH); err != nil {
+		logger.Sugar().Errorf("error umount %s. %v", mntUrl, err)
+		return err
+	}
+	return nil
+}
+
+func (o *OverlayDriver) Remove(containerName string) error {
+	if err := o.Unmount(containerName); err != nil {
+		return err
+	}
+	workUrl := fmt.Sprintf(WorkLayer, os.Getenv("HOME"), containerName)
+	for _, dir := range []string{mntUrlOf(containerName), writeUrlOf(containerName), workUrl} {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Sugar().Errorf("error remove %s. %v", dir, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// overlayfs的upperdir本身就是容器的改动，删除的文件已经以whiteout的形式存在
+// 数据卷绑定挂载在合并后的挂载点上，不会出现在upperdir中
+func (o *OverlayDriver) Diff(containerName string, lowerDirs []string, volume string) (string, func(), error) {
+	writeUrl := writeUrlOf(containerName)
+	if exist, _ := pathExists(writeUrl); !exist {
+		return "", nil, fmt.Errorf("write layer of %s does not exist", containerName)
+	}
+	return writeUrl, func() {}, nil
+}
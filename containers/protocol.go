@@ -0,0 +1,219 @@
+package containers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 父进程与init进程之间的通信协议版本，消息格式变化时递增
+const InitProtocolVersion = 1
+
+// init进程中管道的文件描述符，对应cmd.ExtraFiles的顺序
+const (
+	initConfigFd = 3
+	initErrorFd  = 4
+)
+
+// 消息长度的上限，防止读取到错误的长度时分配过多内存
+const maxInitMessageSize = 16 << 20
+
+// 父进程发送给init进程的配置
+type InitConfig struct {
+	Version  int         `json:"version"`
+	Args     []string    `json:"args"`
+	Env      []string    `json:"env"`
+	Cwd      string      `json:"cwd"`
+	User     string      `json:"user"`
+	Hostname string      `json:"hostname"`
+	Mounts   []InitMount `json:"mounts"`
+	Rlimits  []Rlimit    `json:"rlimits"`
+}
+
+// 在pivot_root之前执行的挂载，Target是容器内的路径
+type InitMount struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Type   string  `json:"type"`
+	Flags  uintptr `json:"flags"`
+	Data   string  `json:"data"`
+}
+
+// 容器进程的资源限制，Type为setrlimit的资源编号
+type Rlimit struct {
+	Type int    `json:"type"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// 父进程与init进程通信使用的管道
+type initPipe struct {
+	// 父进程通过config发送InitConfig，init进程从childConfig读取
+	config      *os.File
+	childConfig *os.File
+	// init进程失败时将错误写入childErr，exec成功后childErr随之关闭
+	errRead  *os.File
+	childErr *os.File
+}
+
+// 创建与init进程通信的管道
+func newInitPipe() (*initPipe, error) {
+	childConfig, config, err := NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	errRead, childErr, err := NewPipe()
+	if err != nil {
+		childConfig.Close()
+		config.Close()
+		return nil, err
+	}
+	return &initPipe{
+		config:      config,
+		childConfig: childConfig,
+		errRead:     errRead,
+		childErr:    childErr,
+	}, nil
+}
+
+// 需要传递给init进程的文件，顺序与initConfigFd和initErrorFd一致
+func (p *initPipe) childFiles() []*os.File {
+	return []*os.File{p.childConfig, p.childErr}
+}
+
+// 关闭管道的所有文件，用于进程未能启动的情况
+func (p *initPipe) Close() {
+	for _, file := range []*os.File{p.config, p.childConfig, p.errRead, p.childErr} {
+		file.Close()
+	}
+}
+
+// 将配置发送给已启动的init进程，并等待其执行用户命令。
+// init进程在exec之前失败时返回其错误
+func (p *initPipe) send(cfg *InitConfig) error {
+	// 关闭父进程中属于子进程的一端，否则读取错误时无法得到EOF
+	p.childConfig.Close()
+	p.childErr.Close()
+	defer p.errRead.Close()
+
+	cfg.Version = InitProtocolVersion
+	logger.Sugar().Infof("Send init config %+v", *cfg)
+	err := writeInitMessage(p.config, cfg)
+	p.config.Close()
+	if err != nil {
+		return fmt.Errorf("send init config err %v", err)
+	}
+
+	msg, err := io.ReadAll(p.errRead)
+	if err != nil {
+		return fmt.Errorf("read init error err %v", err)
+	}
+	if len(msg) > 0 {
+		return fmt.Errorf("container init: %s", msg)
+	}
+	return nil
+}
+
+// 写入一条消息：4字节大端序的长度，之后是json编码的内容
+func writeInitMessage(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(b)))
+	if _, err := w.Write(append(header, b...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 读取一条由writeInitMessage写入的消息
+func readInitMessage(r io.Reader, v interface{}) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxInitMessageSize {
+		return fmt.Errorf("init message too large: %d bytes", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// init进程读取父进程发送的配置
+func readInitConfig() (*InitConfig, error) {
+	pipe := os.NewFile(uintptr(initConfigFd), "init-config")
+	defer pipe.Close()
+
+	cfg := &InitConfig{}
+	if err := readInitMessage(pipe, cfg); err != nil {
+		return nil, fmt.Errorf("read init config err %v", err)
+	}
+	if cfg.Version != InitProtocolVersion {
+		return nil, fmt.Errorf("unsupported init protocol version %d, expected %d", cfg.Version, InitProtocolVersion)
+	}
+	if len(cfg.Args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+	return cfg, nil
+}
+
+// 打开向父进程报告错误的管道，exec成功后自动关闭
+func openInitErrorPipe() *os.File {
+	syscall.CloseOnExec(initErrorFd)
+	return os.NewFile(uintptr(initErrorFd), "init-error")
+}
+
+// --ulimit参数支持的资源名称
+var rlimitTypes = map[string]int{
+	"core":    unix.RLIMIT_CORE,
+	"cpu":     unix.RLIMIT_CPU,
+	"data":    unix.RLIMIT_DATA,
+	"fsize":   unix.RLIMIT_FSIZE,
+	"nofile":  unix.RLIMIT_NOFILE,
+	"stack":   unix.RLIMIT_STACK,
+	"as":      unix.RLIMIT_AS,
+	"memlock": unix.RLIMIT_MEMLOCK,
+	"nproc":   unix.RLIMIT_NPROC,
+}
+
+// 解析--ulimit参数，格式为name=soft[:hard]，未指定hard时与soft相同
+func parseUlimits(ulimits []string) ([]Rlimit, error) {
+	var rlimits []Rlimit
+	for _, ulimit := range ulimits {
+		fields := strings.SplitN(ulimit, "=", 2)
+		resource, ok := rlimitTypes[fields[0]]
+		if len(fields) != 2 || !ok {
+			return nil, fmt.Errorf("invalid ulimit %s", ulimit)
+		}
+		values := strings.SplitN(fields[1], ":", 2)
+		soft, err := strconv.ParseUint(values[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %s", ulimit)
+		}
+		hard := soft
+		if len(values) == 2 {
+			if hard, err = strconv.ParseUint(values[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid ulimit %s", ulimit)
+			}
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("ulimit %s: soft limit is greater than hard limit", ulimit)
+		}
+		rlimits = append(rlimits, Rlimit{Type: resource, Soft: soft, Hard: hard})
+	}
+	return rlimits, nil
+}
@@ -2,6 +2,8 @@ package containers
 
 import (
 	"fmt"
+	"miniker/images"
+	"miniker/networks"
 	"os"
 )
 
@@ -17,12 +19,22 @@ func removeContainer(containerName string) {
 		logger.Sugar().Errorf("Container status is not exit")
 		return
 	}
-	// 删除mntUrl
-	mntUrl := fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
-	if err := os.RemoveAll(mntUrl); err != nil {
-		logger.Sugar().Errorf("remove %s err %v", mntUrl, err)
+	// 删除cgroup，防止stop时进程尚未退出导致cgroup残留
+	destroyContainerCgroup(containerInfo)
+	// 释放stop时未能释放的网络端点
+	networks.DisconnectAll(containerName)
+	// 使用创建容器时的存储驱动删除挂载点和读写层
+	driver, err := getStorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		logger.Sugar().Error(err)
 		return
 	}
+	if err := driver.Remove(containerName); err != nil {
+		logger.Sugar().Errorf("remove workspace of %s err %v", containerName, err)
+		return
+	}
+	// 释放镜像层的引用
+	images.ReleaseLayers(containerInfo.Layers, containerInfo.Id)
 	// 删除容器信息
 	dirUrl := fmt.Sprintf(DefaultInfoLocation, containerName)
 	if err := os.RemoveAll(dirUrl); err != nil {
@@ -1,65 +1,186 @@
 package containers
 
 import (
-	"errors"
 	"fmt"
+	"miniker/images"
 	"miniker/networks"
 	"miniker/subsystems"
+	"net"
 	"os"
 	"os/exec"
 	"path"
-	"strings"
 	"syscall"
 )
 
 // run命令的主要执行逻辑
-func Run(tty bool, args []string, cfg *subsystems.SubsystemConfig, vol, cName, iName, netName string, portM []string) {
+func Run(tty bool, args, env []string, rlimits []Rlimit, cfg *subsystems.SubsystemConfig, vol, cName, iName, netName, storageDriver, workDir, user, hostname string, aliases, portM []string) error {
+	containerId := generateId()
 	if cName == "" {
-		cName = generateId()
+		cName = containerId
+	}
+	if hostname == "" {
+		hostname = containerId
+	}
+	driver, err := getStorageDriver(storageDriver)
+	if err != nil {
+		return err
+	}
+
+	// 准备镜像的只读层，并记录容器对镜像层的引用，防止使用中的层被删除
+	img, err := images.PrepareImage(iName)
+	if err != nil {
+		return err
+	}
+	layers := img.Layers
+
+	// 用户未指定命令时使用镜像配置中的默认命令
+	config := img.Config.Copy()
+	if len(args) > 0 {
+		config.Cmd = args
+	}
+	if workDir != "" {
+		config.WorkingDir = workDir
+	}
+	if user != "" {
+		config.User = user
+	}
+	args = config.Command(nil)
+	// 容器使用独立的环境变量，不继承宿主机的环境
+	env = containerEnv(config.Env, env)
+	config.Env = env
+	if len(args) == 0 {
+		return fmt.Errorf("no command specified and image %s has no default command", iName)
 	}
 
-	parent, writePipe := NewParentProcess(tty, vol, cName, iName)
+	if err := images.AcquireLayers(layers, containerId); err != nil {
+		return err
+	}
+
+	parent, pipe := NewParentProcess(tty, vol, cName, layers, driver)
 	if parent == nil {
-		logger.Sugar().Error("Failed to create container process")
-		return
+		deleteWorkSpace(cName, vol, driver)
+		images.ReleaseLayers(layers, containerId)
+		return fmt.Errorf("failed to create container process")
 	}
 	if err := parent.Start(); err != nil {
-		logger.Sugar().Error(err)
-		return
+		pipe.Close()
+		deleteWorkSpace(cName, vol, driver)
+		images.ReleaseLayers(layers, containerId)
+		return err
 	}
 
-	cName = recordContainerInfo(parent.Process.Pid, cName, args)
+	// 每个容器使用独立的cgroup，路径为miniker/{containerId}
+	cgroupPath := containerCgroupPath(containerId)
+	cName = recordContainerInfo(parent.Process.Pid, &ContainerInfo{
+		Id:            containerId,
+		Name:          cName,
+		Image:         iName,
+		ImageId:       img.Id,
+		Layers:        layers,
+		Config:        config,
+		Env:           env,
+		Hostname:      hostname,
+		Volume:        vol,
+		PortMapping:   portM,
+		CgroupPath:    cgroupPath,
+		StorageDriver: driver.Name(),
+	}, args)
 	// 创建cgroup管理器
-	cgroupManager := subsystems.NewCgroupManager("miniker", cfg)
+	cgroupManager := subsystems.NewCgroupManager(cgroupPath, cfg)
 	// 设置资源限制
 	cgroupManager.Set()
 	// 将容器进程加入到cgroup
 	cgroupManager.Apply(parent.Process.Pid)
 
-	// 将容器连接到指定网络
-	if err := networks.Connect(netName, cName, []string{}, parent.Process.Pid); err != nil {
-		logger.Sugar().Error(err)
-	}
-	// 将父进程的命令参数传递给子进程
-	sendCommandsToPipe(writePipe, args)
-
-	if tty {
-		parent.Wait()
+	// 释放容器占用的资源
+	cleanup := func() {
 		// 删除工作目录
-		deleteWorkSpace(cName, vol)
+		deleteWorkSpace(cName, vol, driver)
+		// 释放镜像层的引用
+		images.ReleaseLayers(layers, containerId)
 		// 删除容器信息
 		deleteContainerInfo(cName)
+		// 释放容器的网络端点
+		networks.DisconnectAll(cName)
 		// 释放cgroup资源
 		cgroupManager.Destroy()
 	}
-	logger.Sugar().Info(tty)
-	// os.Exit(0)
+
+	// 将容器连接到指定网络
+	var ip, nameserver net.IP
+	if netName != "" {
+		endpoint, err := networks.Connect(netName, cName, aliases, portM, parent.Process.Pid)
+		if err != nil {
+			// 网络或端口映射不可用时不启动容器，如映射的端口已被占用
+			pipe.Close()
+			parent.Wait()
+			cleanup()
+			return err
+		}
+		// 容器使用网络的dns服务，该服务监听在网关ip上
+		ip = endpoint.IPAddress
+		nameserver = endpoint.NetWork.IpRange.IP
+	}
+	// 生成容器的hosts和resolv.conf
+	hostMounts, err := setUpHostFiles(cName, hostname, ip, nameserver)
+	if err != nil {
+		// 关闭管道后子进程读取配置失败并退出
+		pipe.Close()
+		parent.Wait()
+		cleanup()
+		return err
+	}
+	// 将容器的配置传递给子进程，并等待子进程执行用户命令
+	err = pipe.send(&InitConfig{
+		Args:     args,
+		Env:      env,
+		Cwd:      config.WorkingDir,
+		User:     config.User,
+		Hostname: hostname,
+		Mounts:   append(defaultMounts(), hostMounts...),
+		Rlimits:  rlimits,
+	})
+	if err != nil {
+		parent.Wait()
+		cleanup()
+		return err
+	}
+
+	if tty {
+		parent.Wait()
+		cleanup()
+	}
+	return nil
+}
+
+// 容器默认的挂载：/proc和/dev
+func defaultMounts() []InitMount {
+	return []InitMount{
+		{
+			Source: "proc",
+			Target: "/proc",
+			Type:   "proc",
+			Flags:  syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV,
+		},
+		{
+			Source: "tmpfs",
+			Target: "/dev",
+			Type:   "tmpfs",
+			Flags:  syscall.MS_NOSUID | syscall.MS_STRICTATIME,
+			Data:   "mode=755",
+		},
+	}
+}
+
+// 获取容器cgroup的相对路径
+func containerCgroupPath(containerId string) string {
+	return path.Join(CgroupRoot, containerId)
 }
 
 // 创建子进程，执行init命令
-func NewParentProcess(createTty bool, volume, containerName, imageName string) (*exec.Cmd, *os.File) {
+func NewParentProcess(createTty bool, volume, containerName string, layers []string, driver StorageDriver) (*exec.Cmd, *initPipe) {
 	// 创建管道，用于进程间通信
-	readPipe, writePipe, err := NewPipe()
+	pipe, err := newInitPipe()
 	if err != nil {
 		logger.Sugar().Error("error create pipe :", err)
 		return nil, nil
@@ -89,6 +210,12 @@ func NewParentProcess(createTty bool, volume, containerName, imageName string) (
 			},
 		},
 	}
+	// 以root运行时映射更多的id，容器中才能切换到非root用户并设置附加组
+	if os.Getuid() == 0 {
+		cmd.SysProcAttr.UidMappings[0].Size = idMappingSize
+		cmd.SysProcAttr.GidMappings[0].Size = idMappingSize
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+	}
 
 	// 重定向标准输入、标准输出和标准错误
 	if createTty {
@@ -99,6 +226,7 @@ func NewParentProcess(createTty bool, volume, containerName, imageName string) (
 		logFile, err := createLogFile(containerName)
 		if err != nil {
 			logger.Sugar().Errorf("create log file err %v", err)
+			pipe.Close()
 			return nil, nil
 		}
 		// cmd.Stdin = os.Stdin
@@ -106,37 +234,21 @@ func NewParentProcess(createTty bool, volume, containerName, imageName string) (
 		cmd.Stderr = logFile
 	}
 
-	// 将`readPipe`传递给新进程，用于读取父进程传递给它的消息
-	cmd.ExtraFiles = []*os.File{readPipe}
+	// 将管道传递给新进程，用于读取父进程传递给它的消息和报告错误
+	cmd.ExtraFiles = pipe.childFiles()
 	// 创建工作目录
-	if err := NewWorkSpace(imageName, containerName, volume); err != nil {
+	if err := NewWorkSpace(layers, containerName, volume, driver); err != nil {
+		pipe.Close()
 		return nil, nil
 	}
 
-	cmd.Dir = fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
-	return cmd, writePipe
-}
-
-func sendCommandsToPipe(pipe *os.File, commands []string) {
-	msgs := strings.Join(commands, " ")
-	logger.Sugar().Infof("Send msg %s\n", msgs)
-	defer pipe.Close()
-
-	_, err := pipe.WriteString(msgs)
-	if err != nil {
-		logger.Sugar().Error("error send commands :", err)
-	}
+	cmd.Dir = mntUrlOf(containerName)
+	return cmd, pipe
 }
 
 // 为容器创建工作目录
-func NewWorkSpace(imageName, containerName, volume string) error {
-	if err := createReadOnlyLayer(imageName); err != nil {
-		return err
-	}
-	if err := createWriteLayer(containerName); err != nil {
-		return err
-	}
-	if err := createMountPoint(imageName, containerName); err != nil {
+func NewWorkSpace(layers []string, containerName, volume string, driver StorageDriver) error {
+	if err := driver.Mount(containerName, imageLayerDirs(layers)); err != nil {
 		return err
 	}
 	if err := mountVolume(containerName, volume); err != nil {
@@ -145,69 +257,9 @@ func NewWorkSpace(imageName, containerName, volume string) error {
 	return nil
 }
 
-// 创建只读层
-func createReadOnlyLayer(imageName string) error {
-	cur, err := os.Getwd()
-	if err != nil {
-		logger.Sugar().Errorf("cannot get pwd %v", err)
-		return err
-	}
-
-	unTarUrl := path.Join(cur, "resources", imageName) + ".tar"
-	if file, err := os.Stat(unTarUrl); err != nil || file.IsDir() {
-		errMsg := fmt.Sprintf("%s is not a directory", unTarUrl)
-		logger.Sugar().Error(errMsg)
-		return errors.New(errMsg)
-	}
-
-	imageUrl := fmt.Sprintf(ImageUrl, os.Getenv("HOME"), imageName)
-	if err := os.MkdirAll(imageUrl, 0777); err != nil {
-		logger.Sugar().Errorf("error create read only layer. %v", err)
-		return err
-	}
-
-	if _, err := exec.Command("tar", "-xf", unTarUrl, "-C", imageUrl).CombinedOutput(); err != nil {
-		logger.Sugar().Errorf("error tar %s. %v", err, unTarUrl)
-		return err
-	}
-	logger.Sugar().Infof("Create readonly dir %s", imageUrl)
-
-	return nil
-}
-
-// 创建读写层
-func createWriteLayer(containerName string) error {
-	writeUrl := fmt.Sprintf(WriteLayer, os.Getenv("HOME"), containerName)
-	if exist, _ := pathExists(writeUrl); exist {
-		return nil
-	}
-	if err := os.MkdirAll(writeUrl, 0777); err != nil {
-		logger.Sugar().Errorf("error mkdir %s. %v", writeUrl, err)
-		return err
-	}
-	return nil
-}
-
-// 将读写层挂载为aufs
-func createMountPoint(imageName, containerName string) error {
-	mntUrl := fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
-	if err := os.MkdirAll(mntUrl, 0777); err != nil {
-		logger.Sugar().Errorf("Create dir %s err %v", mntUrl, err)
-		return err
-	}
-
-	imageUrl := fmt.Sprintf(ImageUrl, os.Getenv("HOME"), imageName)
-	writeUrl := fmt.Sprintf(WriteLayer, os.Getenv("HOME"), containerName)
-	// 将只读层和可写层挂载到mntUrl
-	dirs := "dirs=" + writeUrl + ":" + imageUrl
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logger.Sugar().Errorf("error mount aufs. %v", err)
-		return err
-	}
-	return nil
+// 获取镜像层的目录，按从上到下的顺序排列
+func imageLayerDirs(layers []string) []string {
+	return images.LayerDirs(layers)
 }
 
 // 检查文件路径是否存在
@@ -223,48 +275,9 @@ func pathExists(path string) (bool, error) {
 }
 
 // 删除容器的工作目录
-func deleteWorkSpace(containerName, volume string) {
-	mntUrl := fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
-	if volume != "" {
-		volumes := volumeUrlExtract(volume)
-		// 如果volumes参数无效
-		if len(volumes) != 2 || volumes[0] == "" || volumes[1] == "" {
-			deleteMountPoint(mntUrl)
-		} else {
-			volUrl := path.Join(mntUrl, volumes[1])
-			deleteMountPointWithVolume(mntUrl, volUrl)
-		}
-	} else {
-		deleteMountPoint(mntUrl)
-	}
-}
-
-// 删除挂载点
-func deleteMountPoint(mntUrl string) error {
-	// 卸载
-	cmd := exec.Command("umount", mntUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logger.Sugar().Errorf("error umount %s. %v", mntUrl, err)
-		return err
-	}
-	// 删除
-	if err := os.RemoveAll(mntUrl); err != nil {
-		logger.Sugar().Errorf("error remove %s. %v", mntUrl, err)
-		return err
-	}
-	return nil
-}
-
-// 删除volume和容器挂载点
-func deleteMountPointWithVolume(mntUrl string, volUrl string) error {
-	// 卸载volume
-	if err := umountVolume(volUrl); err != nil {
-		return err
-	}
-	if err := deleteMountPoint(mntUrl); err != nil {
-		return err
+func deleteWorkSpace(containerName, volume string, driver StorageDriver) {
+	if err := umountContainerVolume(containerName, volume); err != nil {
+		return
 	}
-	return nil
+	driver.Remove(containerName)
 }
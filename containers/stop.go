@@ -1,9 +1,8 @@
 package containers
 
 import (
-	"fmt"
-	"os"
-	"os/exec"
+	"miniker/networks"
+	"miniker/subsystems"
 	"strconv"
 	"syscall"
 )
@@ -30,18 +29,33 @@ func stopContainer(containerName string) {
 		// return
 	}
 
+	// 释放容器的cgroup资源
+	destroyContainerCgroup(containerInfo)
+
+	// 释放容器的网络端点：veth、ip地址、端口映射和dns记录
+	networks.DisconnectAll(containerName)
+
 	// 修改容器状态
 	containerInfo.Status = EXIT
 	containerInfo.Pid = ""
 	updateContainerInfo(containerInfo)
 
-	// 卸载mntUrl
-	mntUrl := fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
-	cmd := exec.Command("umount", mntUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logger.Sugar().Errorf("umount %s err %v", mntUrl, err)
-		// return
+	// 卸载volume和mntUrl，保留读写层以便commit或再次使用
+	umountContainerVolume(containerName, containerInfo.Volume)
+	driver, err := getStorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		logger.Sugar().Error(err)
+		return
+	}
+	if err := driver.Unmount(containerName); err != nil {
+		logger.Sugar().Errorf("umount container %s err %v", containerName, err)
+	}
+}
+
+// 删除容器独占的cgroup
+func destroyContainerCgroup(containerInfo *ContainerInfo) {
+	if containerInfo.CgroupPath == "" {
+		return
 	}
+	subsystems.NewCgroupManager(containerInfo.CgroupPath, nil).Destroy()
 }
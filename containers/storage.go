@@ -0,0 +1,74 @@
+package containers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// 存储驱动接口，负责将镜像的只读层和容器的读写层组合成容器的rootfs
+type StorageDriver interface {
+	// 驱动名
+	Name() string
+	// 将只读层和容器的读写层挂载到容器的挂载点
+	// lowerDirs按从上到下的顺序排列
+	Mount(containerName string, lowerDirs []string) error
+	// 卸载容器的挂载点，保留读写层
+	Unmount(containerName string) error
+	// 删除容器的挂载点和读写层
+	Remove(containerName string) error
+	// 获取容器相对于只读层的改动，返回overlayfs格式的改动目录和清理函数
+	// volume为容器挂载的数据卷，数据卷中的文件不属于容器的改动
+	Diff(containerName string, lowerDirs []string, volume string) (string, func(), error)
+}
+
+var storageDrivers = map[string]StorageDriver{}
+
+func init() {
+	for _, driver := range []StorageDriver{&OverlayDriver{}, &VfsDriver{}} {
+		storageDrivers[driver.Name()] = driver
+	}
+}
+
+// 根据名称获取存储驱动，名称为空时使用默认驱动
+func getStorageDriver(name string) (StorageDriver, error) {
+	if name == "" {
+		name = DefaultStorageDriver
+	}
+	driver, ok := storageDrivers[name]
+	if !ok {
+		return nil, fmt.Errorf("no such storage driver %s", name)
+	}
+	return driver, nil
+}
+
+// 获取容器挂载点的路径
+func mntUrlOf(containerName string) string {
+	return fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
+}
+
+// 获取容器读写层的路径
+func writeUrlOf(containerName string) string {
+	return fmt.Sprintf(WriteLayer, os.Getenv("HOME"), containerName)
+}
+
+// 检查路径是否为挂载点
+func isMountPoint(dir string) (bool, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	target := path.Clean(dir)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) > 4 && fields[4] == target {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
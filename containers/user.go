@@ -0,0 +1,180 @@
+package containers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 容器进程使用的用户
+type execUser struct {
+	Uid    int
+	Gid    int
+	Groups []int
+	Home   string
+}
+
+// /etc/passwd中的一条记录
+type passwdEntry struct {
+	Name string
+	Uid  int
+	Gid  int
+	Home string
+}
+
+// /etc/group中的一条记录
+type groupEntry struct {
+	Name    string
+	Gid     int
+	Members []string
+}
+
+// 根据rootfs中的/etc/passwd和/etc/group解析用户，格式为user[:group]，
+// user和group可以是名称或数字id，未指定用户时使用root
+func resolveUser(rootfs, user string) (*execUser, error) {
+	if user == "" {
+		user = "0"
+	}
+	fields := strings.SplitN(user, ":", 2)
+	passwd, err := readPasswd(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroups(rootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	// 数字id可以不在/etc/passwd中，此时使用默认的组和家目录
+	res := &execUser{Gid: 0, Home: "/"}
+	var entry *passwdEntry
+	if uid, err := strconv.Atoi(fields[0]); err == nil {
+		res.Uid = uid
+		for i := range passwd {
+			if passwd[i].Uid == uid {
+				entry = &passwd[i]
+				break
+			}
+		}
+	} else {
+		for i := range passwd {
+			if passwd[i].Name == fields[0] {
+				entry = &passwd[i]
+				break
+			}
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", fields[0])
+		}
+	}
+	if entry != nil {
+		res.Uid = entry.Uid
+		res.Gid = entry.Gid
+		res.Home = entry.Home
+	}
+
+	if len(fields) == 2 {
+		if gid, err := strconv.Atoi(fields[1]); err == nil {
+			res.Gid = gid
+		} else {
+			found := false
+			for _, group := range groups {
+				if group.Name == fields[1] {
+					res.Gid = group.Gid
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", fields[1])
+			}
+		}
+	}
+
+	// 附加组为/etc/group中包含该用户的组
+	if entry != nil {
+		for _, group := range groups {
+			for _, member := range group.Members {
+				if member == entry.Name && group.Gid != res.Gid {
+					res.Groups = append(res.Groups, group.Gid)
+					break
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// 读取rootfs中的文件，每行按':'分割，文件不存在时返回空
+func readColonFile(rootfs, fileName string) ([][]string, error) {
+	filePath, err := securePath(rootfs, fileName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.Split(line, ":"))
+	}
+	return lines, scanner.Err()
+}
+
+// 读取/etc/passwd，格式为name:password:uid:gid:gecos:home:shell
+func readPasswd(rootfs string) ([]passwdEntry, error) {
+	lines, err := readColonFile(rootfs, "/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	var entries []passwdEntry
+	for _, fields := range lines {
+		if len(fields) < 6 {
+			continue
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		entries = append(entries, passwdEntry{Name: fields[0], Uid: uid, Gid: gid, Home: fields[5]})
+	}
+	return entries, nil
+}
+
+// 读取/etc/group，格式为name:password:gid:members
+func readGroups(rootfs string) ([]groupEntry, error) {
+	lines, err := readColonFile(rootfs, "/etc/group")
+	if err != nil {
+		return nil, err
+	}
+	var entries []groupEntry
+	for _, fields := range lines {
+		if len(fields) < 4 {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		var members []string
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, groupEntry{Name: fields[0], Gid: gid, Members: members})
+	}
+	return entries, nil
+}
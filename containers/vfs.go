@@ -0,0 +1,282 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"miniker/images"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 基于复制的存储驱动，不依赖任何联合文件系统
+// 只读层会被依次复制到容器的挂载点，挂载点本身就是容器的读写层
+type VfsDriver struct{}
+
+// 挂载时rootfs中每个文件的状态，用于计算容器的改动
+type vfsFileStat struct {
+	Mode  uint32 `json:"mode"`
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"`
+	Ctime int64  `json:"ctime"`
+	Uid   uint32 `json:"uid"`
+	Gid   uint32 `json:"gid"`
+}
+
+func (v *VfsDriver) Name() string {
+	return "vfs"
+}
+
+func (v *VfsDriver) Mount(containerName string, lowerDirs []string) error {
+	mntUrl := mntUrlOf(containerName)
+	// 挂载点已存在时说明复制过，直接复用
+	if exist, _ := pathExists(mntUrl); exist {
+		return nil
+	}
+	if err := os.MkdirAll(mntUrl, 0777); err != nil {
+		logger.Sugar().Errorf("error mkdir %s. %v", mntUrl, err)
+		return err
+	}
+
+	// 从最底层开始复制，上层的文件会覆盖下层的文件
+	for i := len(lowerDirs) - 1; i >= 0; i-- {
+		if err := applyLayer(lowerDirs[i], mntUrl); err != nil {
+			logger.Sugar().Errorf("error copy %s to %s. %v", lowerDirs[i], mntUrl, err)
+			os.RemoveAll(mntUrl)
+			return fmt.Errorf("copy layer %s err %v", lowerDirs[i], err)
+		}
+	}
+
+	// 记录初始状态，commit时与之比较得到容器的改动
+	if err := v.recordManifest(containerName); err != nil {
+		logger.Sugar().Errorf("error record manifest of %s. %v", containerName, err)
+		os.RemoveAll(mntUrl)
+		return err
+	}
+	return nil
+}
+
+// 将overlayfs格式的镜像层合并到目标目录，处理其中的whiteout和不透明目录
+func applyLayer(layerDir, target string) error {
+	var whiteouts []string
+	err := filepath.Walk(layerDir, func(fileName string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(layerDir, fileName)
+		if rel == "." {
+			return nil
+		}
+		switch {
+		case images.IsWhiteout(info):
+			whiteouts = append(whiteouts, rel)
+			return os.RemoveAll(path.Join(target, rel))
+		case info.IsDir() && images.IsOpaque(fileName):
+			return os.RemoveAll(path.Join(target, rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	src := strings.TrimSuffix(layerDir, "/") + "/."
+	if output, err := exec.Command("cp", "-a", src, target).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v %s", err, output)
+	}
+
+	// 删除复制过来的whiteout
+	for _, rel := range whiteouts {
+		if err := os.Remove(path.Join(target, rel)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *VfsDriver) manifestPath(containerName string) string {
+	return path.Join(writeUrlOf(containerName), "vfs.json")
+}
+
+// 获取文件的状态
+func statOf(info fs.FileInfo) vfsFileStat {
+	stat := vfsFileStat{
+		Mode:  uint32(info.Mode()),
+		Size:  info.Size(),
+		Mtime: info.ModTime().UnixNano(),
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		stat.Ctime = st.Ctim.Nano()
+		stat.Uid = st.Uid
+		stat.Gid = st.Gid
+	}
+	return stat
+}
+
+// 遍历rootfs，记录每个文件的状态
+func (v *VfsDriver) recordManifest(containerName string) error {
+	mntUrl := mntUrlOf(containerName)
+	manifest := map[string]vfsFileStat{}
+	err := filepath.Walk(mntUrl, func(fileName string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(mntUrl, fileName)
+		if rel != "." {
+			manifest[rel] = statOf(info)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(writeUrlOf(containerName), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(v.manifestPath(containerName), b, 0644)
+}
+
+// 比较rootfs和挂载时的状态，将新增和修改的文件复制到临时目录，删除的文件记录为whiteout。
+// 数据卷直接挂载在rootfs中，跳过数据卷在容器中的路径
+func (v *VfsDriver) Diff(containerName string, lowerDirs []string, volume string) (string, func(), error) {
+	content, err := os.ReadFile(v.manifestPath(containerName))
+	if err != nil {
+		return "", nil, err
+	}
+	manifest := map[string]vfsFileStat{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return "", nil, err
+	}
+
+	diffDir, err := os.MkdirTemp("", "miniker-diff-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(diffDir)
+	}
+
+	mntUrl := mntUrlOf(containerName)
+	volumeRel := volumeRelPath(volume)
+	seen := map[string]bool{}
+	err = filepath.Walk(mntUrl, func(fileName string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(mntUrl, fileName)
+		if rel == "." {
+			return nil
+		}
+		if rel == volumeRel {
+			seen[rel] = true
+			return filepath.SkipDir
+		}
+		seen[rel] = info.IsDir()
+		if old, ok := manifest[rel]; ok && old == statOf(info) {
+			return nil
+		}
+		if err := copyParents(mntUrl, diffDir, rel); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return copyDirMeta(fileName, path.Join(diffDir, rel), info)
+		}
+		if output, err := exec.Command("cp", "-a", fileName, path.Join(diffDir, rel)).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v %s", err, output)
+		}
+		return nil
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	for rel := range manifest {
+		if _, ok := seen[rel]; ok {
+			continue
+		}
+		if volumeRel != "" && strings.HasPrefix(rel, volumeRel+"/") {
+			continue
+		}
+		// 只为最上层被删除的路径创建whiteout
+		if parent := path.Dir(rel); parent != "." && !seen[parent] {
+			continue
+		}
+		if err := copyParents(mntUrl, diffDir, rel); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		if err := syscall.Mknod(path.Join(diffDir, rel), syscall.S_IFCHR, 0); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	return diffDir, cleanup, nil
+}
+
+// 在改动目录中创建rel的所有父目录，目录的权限和属主与rootfs中保持一致
+func copyParents(mntUrl, diffDir, rel string) error {
+	parent := path.Dir(rel)
+	if parent == "." {
+		return nil
+	}
+	if exist, _ := pathExists(path.Join(diffDir, parent)); exist {
+		return nil
+	}
+	if err := copyParents(mntUrl, diffDir, parent); err != nil {
+		return err
+	}
+	info, err := os.Lstat(path.Join(mntUrl, parent))
+	if err != nil {
+		return err
+	}
+	return copyDirMeta(path.Join(mntUrl, parent), path.Join(diffDir, parent), info)
+}
+
+// 创建目录，并复制源目录的权限、属主和修改时间
+func copyDirMeta(src, dst string, info fs.FileInfo) error {
+	if err := os.Mkdir(dst, info.Mode().Perm()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := os.Chmod(dst, info.Mode()&(fs.ModePerm|fs.ModeSticky|fs.ModeSetuid|fs.ModeSetgid)); err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func (v *VfsDriver) Unmount(containerName string) error {
+	return nil
+}
+
+func (v *VfsDriver) Remove(containerName string) error {
+	for _, dir := range []string{mntUrlOf(containerName), writeUrlOf(containerName)} {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Sugar().Errorf("error remove %s. %v", dir, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 数据卷在rootfs中的相对路径，没有数据卷时返回空
+func volumeRelPath(volume string) string {
+	volumes := volumeUrlExtract(volume)
+	if len(volumes) != 2 || volumes[0] == "" || volumes[1] == "" {
+		return ""
+	}
+	return strings.TrimPrefix(path.Clean("/"+volumes[1]), "/")
+}
@@ -2,11 +2,10 @@ package containers
 
 import (
 	"errors"
-	"fmt"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
+	"syscall"
 )
 
 func mountVolume(containerName, volume string) error {
@@ -28,19 +27,16 @@ func mountVolume(containerName, volume string) error {
 		return err
 	}
 
-	mntUrl := fmt.Sprintf(MntUrl, os.Getenv("HOME"), containerName)
-	guestUrl := path.Join(mntUrl, volumeUrls[1])
+	guestUrl := path.Join(mntUrlOf(containerName), volumeUrls[1])
 	if err := os.MkdirAll(guestUrl, 0777); err != nil {
 		logger.Sugar().Errorf("error mkdir %s. %v", guestUrl, err)
 		return err
 	}
 
-	dirs := "dirs=" + hostUrl
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", guestUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logger.Sugar().Errorf("error mount aufs. %v", err)
+	// 将宿主机目录绑定挂载到容器中，与存储驱动无关
+	if err := syscall.Mount(hostUrl, guestUrl, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		logger.Sugar().Errorf("error bind mount %s to %s. %v", hostUrl, guestUrl, err)
+		return err
 	}
 
 	return nil
@@ -53,12 +49,25 @@ func volumeUrlExtract(volume string) []string {
 
 func umountVolume(volUrl string) error {
 	// 卸载volume
-	cmd := exec.Command("umount", volUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := syscall.Unmount(volUrl, syscall.MNT_DETACH); err != nil {
 		logger.Sugar().Errorf("error umount %s. %v", volUrl, err)
 		return err
 	}
 	return nil
 }
+
+// 卸载容器中挂载的volume，volume参数无效时直接返回
+func umountContainerVolume(containerName, volume string) error {
+	if volume == "" {
+		return nil
+	}
+	volumes := volumeUrlExtract(volume)
+	if len(volumes) != 2 || volumes[0] == "" || volumes[1] == "" {
+		return nil
+	}
+	volUrl := path.Join(mntUrlOf(containerName), volumes[1])
+	if mounted, _ := isMountPoint(volUrl); !mounted {
+		return nil
+	}
+	return umountVolume(volUrl)
+}
@@ -0,0 +1,165 @@
+package images
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// OCI镜像层中表示删除文件的前缀
+	WhiteoutPrefix string = ".wh."
+	// OCI镜像层中表示目录不透明，即下层目录的内容全部被删除
+	WhiteoutOpaque string = ".wh..wh..opq"
+	// overlayfs中表示目录不透明的扩展属性
+	OverlayOpaqueXattr string = "trusted.overlay.opaque"
+)
+
+// 判断文件是否为overlayfs格式的whiteout，即设备号为0/0的字符设备
+func IsWhiteout(info fs.FileInfo) bool {
+	if info.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// 判断目录是否为overlayfs格式的不透明目录
+func IsOpaque(dir string) bool {
+	buf := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, OverlayOpaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// 创建overlayfs格式的whiteout
+func createWhiteout(fileName string) error {
+	return syscall.Mknod(fileName, syscall.S_IFCHR, 0)
+}
+
+// 将解压后的OCI格式whiteout转换为overlayfs格式，便于直接用作lowerdir
+func convertWhiteouts(dir string) error {
+	return filepath.WalkDir(dir, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if !strings.HasPrefix(name, WhiteoutPrefix) {
+			return nil
+		}
+		if err := os.RemoveAll(fileName); err != nil {
+			return err
+		}
+		parent := path.Dir(fileName)
+		if name == WhiteoutOpaque {
+			return syscall.Setxattr(parent, OverlayOpaqueXattr, []byte("y"), 0)
+		}
+		return createWhiteout(path.Join(parent, strings.TrimPrefix(name, WhiteoutPrefix)))
+	})
+}
+
+// 将overlayfs格式的目录打包为OCI格式的镜像层
+// whiteout会被转换为.wh.<name>，不透明目录会增加.wh..wh..opq
+func writeLayerTar(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// 记录已经写入的inode，用于生成硬链接
+	inodes := map[uint64]string{}
+
+	err := filepath.Walk(dir, func(fileName string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fileName)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		if IsWhiteout(info) {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path.Join(path.Dir(rel), WhiteoutPrefix+info.Name()),
+				Mode:     0600,
+				ModTime:  info.ModTime(),
+			})
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(fileName); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			header.Uid = int(st.Uid)
+			header.Gid = int(st.Gid)
+			header.Uname = ""
+			header.Gname = ""
+			if info.Mode().IsRegular() && st.Nlink > 1 {
+				if first, ok := inodes[st.Ino]; ok {
+					header.Typeflag = tar.TypeLink
+					header.Linkname = first
+					header.Size = 0
+				} else {
+					inodes[st.Ino] = rel
+				}
+			}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.IsDir() && IsOpaque(fileName) {
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path.Join(rel, WhiteoutOpaque),
+				Mode:     0600,
+				ModTime:  info.ModTime(),
+			}); err != nil {
+				return err
+			}
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// 将目录打包为OCI格式的镜像层文件
+func WriteLayerTarFile(dir string, fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := writeLayerTar(dir, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,75 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+)
+
+// 构建缓存文件，记录缓存键到镜像id的映射
+const BuildCacheName string = "build-cache.json"
+
+// 根据父镜像和构建指令计算缓存键
+func BuildCacheKey(parentId string, parts ...string) string {
+	sum := sha256.Sum256([]byte(parentId + "\n" + strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *ImageStore) loadCache() (map[string]string, error) {
+	cache := map[string]string{}
+	content, err := os.ReadFile(path.Join(s.Root, BuildCacheName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	return cache, json.Unmarshal(content, &cache)
+}
+
+// 查找构建缓存，缓存的镜像已被删除时视为未命中
+func LookupBuildCache(key string) (*Image, bool) {
+	unlock, err := imageStore.lock()
+	if err != nil {
+		return nil, false
+	}
+	defer unlock()
+
+	cache, err := imageStore.loadCache()
+	if err != nil {
+		logger.Sugar().Warnf("load build cache err %v", err)
+		return nil, false
+	}
+	id, ok := cache[key]
+	if !ok {
+		return nil, false
+	}
+	img, err := imageStore.loadImage(id)
+	if err != nil {
+		return nil, false
+	}
+	return img, true
+}
+
+// 记录构建缓存
+func StoreBuildCache(key string, id string) error {
+	unlock, err := imageStore.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cache, err := imageStore.loadCache()
+	if err != nil {
+		return err
+	}
+	cache[key] = id
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(imageStore.Root, BuildCacheName), b, 0644)
+}
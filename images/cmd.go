@@ -0,0 +1,212 @@
+package images
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+func NewImageCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "image",
+		Usage: "miniker image COMMAND",
+		Subcommands: []*cli.Command{
+			NewListCommand(),
+			NewRemoveCommand(),
+			NewTagCommand(),
+			NewInspectCommand(),
+			NewPruneCommand(),
+			NewLoadCommand(),
+			NewSaveCommand(),
+		},
+	}
+}
+
+func NewListCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "ls",
+		Usage: "List images",
+		Action: func(ctx *cli.Context) error {
+			return listImages()
+		},
+	}
+}
+
+func NewRemoveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rm",
+		Usage: "Remove one or more images",
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input image name")
+			}
+			for _, ref := range ctx.Args().Slice() {
+				if err := imageStore.Remove(ref); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "Untagged: %s\n", ref)
+			}
+			return nil
+		},
+	}
+}
+
+func NewTagCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "tag",
+		Usage: "Create a tag TARGET_IMAGE that refers to SOURCE_IMAGE",
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 2 {
+				return errors.New("please input source image and target image")
+			}
+			return imageStore.Tag(ctx.Args().Get(0), ctx.Args().Get(1))
+		},
+	}
+}
+
+func NewInspectCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "inspect",
+		Usage: "Display detailed information on an image",
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input image name")
+			}
+			img, err := imageStore.Get(ctx.Args().Get(0))
+			if err != nil {
+				return err
+			}
+			b, err := json.MarshalIndent(img, "", "    ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stdout, string(b))
+			return nil
+		},
+	}
+}
+
+func NewPruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "Remove unused images and layers",
+		Action: func(ctx *cli.Context) error {
+			pruned, err := imageStore.Prune()
+			for _, id := range pruned {
+				fmt.Fprintf(os.Stdout, "Deleted: %s\n", id)
+			}
+			return err
+		},
+	}
+}
+
+func NewLoadCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "load",
+		Usage: "Load images from an OCI image-layout or docker save archive",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "input",
+				Aliases:  []string{"i"},
+				Usage:    "Read from tar archive file or directory",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "name",
+				Usage: "Image name used when the archive only records tags",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			loaded, err := LoadArchive(ctx.String("input"), ctx.String("name"))
+			for _, name := range loaded {
+				fmt.Fprintf(os.Stdout, "Loaded image: %s\n", name)
+			}
+			return err
+		},
+	}
+}
+
+func NewSaveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "save",
+		Usage: "Save one or more images to an OCI image-layout archive",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "output",
+				Aliases:  []string{"o"},
+				Usage:    "Write to a tar archive file",
+				Required: true,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input image name")
+			}
+			return SaveArchive(ctx.String("output"), ctx.Args().Slice())
+		},
+	}
+}
+
+func NewPullCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "pull",
+		Usage: "Pull an image from a registry. miniker pull [registry/]name[:tag|@digest]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "plain-http",
+				Usage: "Use http instead of https to access the registry",
+			},
+			&cli.StringFlag{
+				Name:  "credentials",
+				Usage: "Registry credentials file (default $HOME/.miniker/auth.json)",
+			},
+			&cli.StringFlag{
+				Name:  "platform",
+				Usage: "Platform of multi-platform images, format: os/arch[/variant]",
+				Value: DefaultPlatform,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input image name")
+			}
+			img, err := Pull(ctx.Args().Get(0), ctx.Bool("plain-http"), ctx.String("credentials"), ctx.String("platform"))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "Digest: %s\n", img.Id)
+			return nil
+		},
+	}
+}
+
+func NewPushCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "push",
+		Usage: "Push an image to a registry. miniker push [registry/]name[:tag]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "plain-http",
+				Usage: "Use http instead of https to access the registry",
+			},
+			&cli.StringFlag{
+				Name:  "credentials",
+				Usage: "Registry credentials file (default $HOME/.miniker/auth.json)",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input image name")
+			}
+			digest, err := Push(ctx.Args().Get(0), ctx.Bool("plain-http"), ctx.String("credentials"))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "Digest: %s\n", digest)
+			return nil
+		},
+	}
+}
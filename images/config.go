@@ -0,0 +1,51 @@
+package images
+
+// 镜像的运行配置，描述如何运行镜像
+type ImageConfig struct {
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+// 复制一份配置，避免修改镜像中的原始配置
+func (c *ImageConfig) Copy() *ImageConfig {
+	if c == nil {
+		return &ImageConfig{}
+	}
+	cfg := &ImageConfig{
+		Entrypoint: append([]string(nil), c.Entrypoint...),
+		Cmd:        append([]string(nil), c.Cmd...),
+		Env:        append([]string(nil), c.Env...),
+		WorkingDir: c.WorkingDir,
+		User:       c.User,
+	}
+	if c.ExposedPorts != nil {
+		cfg.ExposedPorts = map[string]struct{}{}
+		for port := range c.ExposedPorts {
+			cfg.ExposedPorts[port] = struct{}{}
+		}
+	}
+	if c.Labels != nil {
+		cfg.Labels = map[string]string{}
+		for k, v := range c.Labels {
+			cfg.Labels[k] = v
+		}
+	}
+	return cfg
+}
+
+// 根据用户输入的命令计算容器实际执行的命令
+// 用户未输入命令时使用镜像的Cmd，Entrypoint始终位于命令之前
+func (c *ImageConfig) Command(args []string) []string {
+	if c == nil {
+		return args
+	}
+	if len(args) == 0 {
+		args = c.Cmd
+	}
+	return append(append([]string(nil), c.Entrypoint...), args...)
+}
@@ -0,0 +1,19 @@
+package images
+
+var (
+	// 镜像的存储目录，与当前工作目录和用户无关
+	DefaultImagePath string = "/var/lib/miniker/images/"
+	// 解压后的镜像层存放目录，每一层以其tar包内容的摘要命名
+	DefaultLayerPath string = "/var/lib/miniker/layers/"
+	// 镜像名到镜像id的映射
+	RepositoriesName string = "repositories.json"
+	// 旧版本中镜像tar包所在目录，相对于当前工作目录
+	ResourcesDir string = "resources"
+	// 仓库认证信息文件
+	DefaultCredentialsPath string = "%s/.miniker/auth.json"
+	DefaultTag             string = "latest"
+	LockName               string = ".lock"
+	DigestAlgo             string = "sha256"
+	// 拉取多平台镜像时默认选择的平台
+	DefaultPlatform string = "linux/amd64"
+)
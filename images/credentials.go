@@ -0,0 +1,65 @@
+package images
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 仓库认证信息文件，格式与docker的config.json相同
+// {"auths": {"registry:5000": {"auth": "base64(username:password)"}}}
+type credentialsFile struct {
+	Auths map[string]registryAuth `json:"auths"`
+}
+
+type registryAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// 获取默认的认证信息文件路径
+func defaultCredentialsPath() string {
+	return fmt.Sprintf(DefaultCredentialsPath, os.Getenv("HOME"))
+}
+
+// 从认证信息文件中读取仓库的用户名和密码，文件不存在时匿名访问
+func loadCredentials(fileName string, registry string) (string, string, error) {
+	if fileName == "" {
+		fileName = defaultCredentialsPath()
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	creds := &credentialsFile{}
+	if err := json.Unmarshal(content, creds); err != nil {
+		return "", "", fmt.Errorf("parse credentials file %s err %v", fileName, err)
+	}
+
+	auth, ok := creds.Auths[registry]
+	if !ok && registry == DefaultRegistry {
+		// docker hub的认证信息通常以该地址为键
+		auth, ok = creds.Auths["https://index.docker.io/v1/"]
+	}
+	if !ok {
+		return "", "", nil
+	}
+	if auth.Auth == "" {
+		return auth.Username, auth.Password, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("decode auth of %s err %v", registry, err)
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid auth of %s", registry)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,86 @@
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// docker save生成的归档中记录镜像信息的文件
+const DockerManifestFile string = "manifest.json"
+
+// manifest.json中的一项，对应一个镜像
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// 获取归档中文件的路径，防止通过".."访问归档目录之外的文件
+func archivePath(dir, name string) string {
+	return path.Join(dir, path.Clean("/"+name))
+}
+
+// 导入docker save格式的镜像，按顺序导入每一层，并使用归档中的repo:tag作为镜像名
+func loadDockerArchive(dir string, defaultName string) ([]string, error) {
+	content, err := os.ReadFile(path.Join(dir, DockerManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []dockerManifest
+	if err := json.Unmarshal(content, &manifests); err != nil {
+		return nil, err
+	}
+
+	var loaded []string
+	for _, manifest := range manifests {
+		cfgContent, err := os.ReadFile(archivePath(dir, manifest.Config))
+		if err != nil {
+			return loaded, err
+		}
+		cfg := &ConfigFile{}
+		if err := json.Unmarshal(cfgContent, cfg); err != nil {
+			return loaded, err
+		}
+		if len(cfg.RootFS.DiffIDs) != 0 && len(cfg.RootFS.DiffIDs) != len(manifest.Layers) {
+			return loaded, fmt.Errorf("config %s has %d diff ids but manifest has %d layers",
+				manifest.Config, len(cfg.RootFS.DiffIDs), len(manifest.Layers))
+		}
+
+		var layers []string
+		for i, layerFile := range manifest.Layers {
+			file, err := os.Open(archivePath(dir, layerFile))
+			if err != nil {
+				return loaded, err
+			}
+			id, err := importLayer(file, "")
+			file.Close()
+			if err != nil {
+				return loaded, fmt.Errorf("import layer %s err %v", layerFile, err)
+			}
+			// 镜像层id为未压缩tar包的摘要，应与配置中的diff_id一致
+			if len(cfg.RootFS.DiffIDs) != 0 && cfg.RootFS.DiffIDs[i] != id {
+				return loaded, fmt.Errorf("layer %s digest %s does not match diff id %s", layerFile, id, cfg.RootFS.DiffIDs[i])
+			}
+			layers = append(layers, id)
+		}
+
+		names := manifest.RepoTags
+		if len(names) == 0 {
+			names = []string{defaultName}
+		}
+		for _, name := range names {
+			img, err := imageStore.Register(name, "", layers, configFromOCI(cfg))
+			if err != nil {
+				return loaded, err
+			}
+			if name == "" {
+				name = img.Id
+			}
+			logger.Sugar().Infof("Loaded image %s %s", name, img.Id)
+			loaded = append(loaded, name)
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,111 @@
+package images
+
+import (
+	"os"
+	"path"
+)
+
+// 准备镜像的只读层，返回的镜像中包含按从下到上排列的镜像层id和运行配置
+func PrepareImage(imageName string) (*Image, error) {
+	img, err := imageStore.Get(imageName)
+	if err == nil {
+		return img, nil
+	}
+
+	// 兼容旧版本，镜像不在存储中时从当前目录的resources中导入
+	img, legacyErr := importLegacyImage(imageName)
+	if legacyErr != nil {
+		logger.Sugar().Warnf("import legacy image %s err %v", imageName, legacyErr)
+		return nil, err
+	}
+	return img, nil
+}
+
+// 将resources/<imageName>.tar导入镜像存储
+func importLegacyImage(imageName string) (*Image, error) {
+	cur, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	tarFile := path.Join(cur, ResourcesDir, imageName) + ".tar"
+	return ImportTar(tarFile, imageName, nil)
+}
+
+// 将包含完整rootfs的tar包导入为单层镜像
+func ImportTar(tarFile, imageName string, config *ImageConfig) (*Image, error) {
+	if file, err := os.Stat(tarFile); err != nil {
+		return nil, err
+	} else if file.IsDir() {
+		return nil, os.ErrInvalid
+	}
+
+	id, err := layerStore.Extract(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	return imageStore.Register(imageName, "", []string{id}, config)
+}
+
+// 将容器的改动提交为新的镜像层，新镜像由父镜像的所有层加上新层组成
+// diffDir为overlayfs格式的改动目录
+func CommitLayer(diffDir, imageName, parentId string, parentLayers []string, config *ImageConfig) (*Image, error) {
+	if err := os.MkdirAll(layerStore.Root, 0755); err != nil {
+		return nil, err
+	}
+	tmpFile, err := os.CreateTemp(layerStore.Root, "commit-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	if err := WriteLayerTarFile(diffDir, tmpFile.Name()); err != nil {
+		return nil, err
+	}
+	id, err := layerStore.Extract(tmpFile.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	layers := append(append([]string(nil), parentLayers...), id)
+	return imageStore.Register(imageName, parentId, layers, config)
+}
+
+// 在父镜像的基础上修改运行配置，生成不增加镜像层的新镜像
+func DeriveImage(parent *Image, config *ImageConfig) (*Image, error) {
+	return imageStore.Register("", parent.Id, parent.Layers, config)
+}
+
+// 为镜像添加名称
+func TagImage(ref, name string) error {
+	return imageStore.Tag(ref, name)
+}
+
+// 获取镜像层对应的目录，按从上到下的顺序排列，可直接用作overlay的lowerdir
+func LayerDirs(layers []string) []string {
+	dirs := make([]string, 0, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		dirs = append(dirs, layerStore.Path(layers[i]))
+	}
+	return dirs
+}
+
+// 记录容器正在使用的镜像层，任一层失败时回滚已添加的引用
+func AcquireLayers(layers []string, owner string) error {
+	for i, id := range layers {
+		if err := layerStore.Acquire(id, owner); err != nil {
+			ReleaseLayers(layers[:i], owner)
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除容器对镜像层的引用
+func ReleaseLayers(layers []string, owner string) {
+	for _, id := range layers {
+		if err := layerStore.Release(id, owner); err != nil {
+			logger.Sugar().Errorf("release layer %s of %s err %v", id, owner, err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+var logger *zap.Logger
+
+func init() {
+	logger, _ = zap.NewProduction()
+}
+
+// 镜像层存储
+// 每一层只解压一次，目录名为tar包的内容摘要，使用者以引用的形式记录在层的元数据中
+type LayerStore struct {
+	Root string
+}
+
+// 镜像层的元数据
+type layerMeta struct {
+	Id   string   `json:"id"`
+	Size int64    `json:"size"`
+	Refs []string `json:"refs"`
+}
+
+// 默认的镜像层存储
+var layerStore = &LayerStore{
+	Root: DefaultLayerPath,
+}
+
+// 计算文件内容的摘要，格式为sha256:<hex>
+func fileDigest(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return DigestAlgo + ":" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// 获取摘要中的十六进制部分，用作目录名
+func digestHex(id string) string {
+	return strings.TrimPrefix(id, DigestAlgo+":")
+}
+
+// 获取镜像层解压后的目录
+func (s *LayerStore) Path(id string) string {
+	return path.Join(s.Root, digestHex(id)) + "/"
+}
+
+func (s *LayerStore) metaPath(id string) string {
+	return path.Join(s.Root, digestHex(id)+".json")
+}
+
+// 对整个存储加文件锁，防止多个miniker进程同时解压或修改引用
+func (s *LayerStore) lock() (func(), error) {
+	return lockDir(s.Root)
+}
+
+// 对目录加排他的文件锁，返回解锁函数
+func lockDir(dir string) (func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(path.Join(dir, LockName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+// 解压tar包为镜像层，相同内容的tar包只会解压一次
+func (s *LayerStore) Extract(tarFile string) (string, error) {
+	id, err := fileDigest(tarFile)
+	if err != nil {
+		logger.Sugar().Errorf("digest %s err %v", tarFile, err)
+		return "", err
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if s.exists(id) {
+		logger.Sugar().Infof("layer %s already exists", id)
+		return id, nil
+	}
+
+	// 先解压到临时目录，完成后再重命名，避免其他容器看到不完整的层
+	layerDir := s.Path(id)
+	tmpDir := strings.TrimSuffix(layerDir, "/") + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", err
+	}
+	if output, err := exec.Command("tar", "-xf", tarFile, "-C", tmpDir).CombinedOutput(); err != nil {
+		logger.Sugar().Errorf("error tar %s. %v %s", tarFile, err, output)
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	// 将OCI格式的whiteout转换为overlayfs格式
+	if err := convertWhiteouts(tmpDir); err != nil {
+		logger.Sugar().Errorf("error convert whiteouts of %s. %v", tarFile, err)
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	if err := os.Rename(tmpDir, strings.TrimSuffix(layerDir, "/")); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	size, err := dirSize(layerDir)
+	if err != nil {
+		return "", err
+	}
+	if err := s.dumpMeta(&layerMeta{Id: id, Size: size}); err != nil {
+		return "", err
+	}
+	logger.Sugar().Infof("Create layer %s from %s", layerDir, tarFile)
+	return id, nil
+}
+
+func (s *LayerStore) exists(id string) bool {
+	if _, err := os.Stat(s.metaPath(id)); err != nil {
+		return false
+	}
+	_, err := os.Stat(s.Path(id))
+	return err == nil
+}
+
+func (s *LayerStore) loadMeta(id string) (*layerMeta, error) {
+	content, err := os.ReadFile(s.metaPath(id))
+	if err != nil {
+		return nil, err
+	}
+	meta := &layerMeta{}
+	if err := json.Unmarshal(content, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (s *LayerStore) dumpMeta(meta *layerMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.metaPath(meta.Id), b, 0644)
+}
+
+// 记录`owner`正在使用镜像层
+func (s *LayerStore) Acquire(id string, owner string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := s.loadMeta(id)
+	if err != nil {
+		return fmt.Errorf("no such layer %s", id)
+	}
+	for _, ref := range meta.Refs {
+		if ref == owner {
+			return nil
+		}
+	}
+	meta.Refs = append(meta.Refs, owner)
+	sort.Strings(meta.Refs)
+	return s.dumpMeta(meta)
+}
+
+// 删除`owner`对镜像层的引用
+func (s *LayerStore) Release(id string, owner string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := s.loadMeta(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	refs := meta.Refs[:0]
+	for _, ref := range meta.Refs {
+		if ref != owner {
+			refs = append(refs, ref)
+		}
+	}
+	meta.Refs = refs
+	return s.dumpMeta(meta)
+}
+
+// 删除没有被引用的镜像层，仍被引用时返回错误
+func (s *LayerStore) Remove(id string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := s.loadMeta(id)
+	if err != nil {
+		return err
+	}
+	if len(meta.Refs) > 0 {
+		return fmt.Errorf("layer %s is used by %s", id, strings.Join(meta.Refs, ","))
+	}
+	if err := os.RemoveAll(s.Path(id)); err != nil {
+		return err
+	}
+	return os.Remove(s.metaPath(id))
+}
+
+// 获取镜像层解压后的大小
+func (s *LayerStore) Size(id string) int64 {
+	meta, err := s.loadMeta(id)
+	if err != nil {
+		return 0
+	}
+	return meta.Size
+}
+
+// 获取镜像层的引用者
+func (s *LayerStore) Refs(id string) []string {
+	meta, err := s.loadMeta(id)
+	if err != nil {
+		return nil
+	}
+	return meta.Refs
+}
+
+// 列出所有镜像层
+func (s *LayerStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		ids = append(ids, DigestAlgo+":"+strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return ids, nil
+}
+
+// 统计目录中普通文件的总大小
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
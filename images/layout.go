@@ -0,0 +1,330 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// 导入镜像归档，支持OCI image-layout和docker save两种格式
+// 归档可以是tar包，也可以是解压后的目录
+// 归档中只有tag而没有完整镜像名时，使用defaultName作为镜像名
+func LoadArchive(archive string, defaultName string) ([]string, error) {
+	dir, cleanup, err := openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if exist, _ := fileExists(path.Join(dir, OCIIndexFile)); exist {
+		return loadOCILayout(dir, defaultName)
+	}
+	if exist, _ := fileExists(path.Join(dir, DockerManifestFile)); exist {
+		return loadDockerArchive(dir, defaultName)
+	}
+	return nil, fmt.Errorf("unknown image archive format %s", archive)
+}
+
+// 将tar包解压到临时目录
+func openArchive(archive string) (string, func(), error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return archive, func() {}, nil
+	}
+
+	if err := os.MkdirAll(layerStore.Root, 0755); err != nil {
+		return "", nil, err
+	}
+	tmpDir, err := os.MkdirTemp(layerStore.Root, "archive-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(tmpDir)
+	}
+	if output, err := exec.Command("tar", "-xf", archive, "-C", tmpDir).CombinedOutput(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("error untar %s. %v %s", archive, err, output)
+	}
+	return tmpDir, cleanup, nil
+}
+
+func fileExists(fileName string) (bool, error) {
+	_, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 获取OCI目录中blob的路径
+func blobPath(dir, digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] != DigestAlgo || !isHex(parts[1]) {
+		return "", fmt.Errorf("invalid digest %s", digest)
+	}
+	return path.Join(dir, "blobs", parts[0], parts[1]), nil
+}
+
+// 读取并校验json格式的blob
+func readJSONBlob(dir string, desc Descriptor, v interface{}) error {
+	fileName, err := blobPath(dir, desc.Digest)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if err := verifyContent(content, desc.Digest); err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// 导入OCI image-layout格式的镜像
+func loadOCILayout(dir string, defaultName string) ([]string, error) {
+	content, err := os.ReadFile(path.Join(dir, OCIIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, err
+	}
+
+	var loaded []string
+	for _, desc := range index.Manifests {
+		name, err := refNameOf(desc.Annotations, defaultName)
+		if err != nil {
+			return loaded, err
+		}
+
+		// 多平台镜像需要再从索引中选出当前平台的manifest
+		manifestDesc := desc
+		for isIndex(manifestDesc.MediaType) {
+			sub := &Index{}
+			if err := readJSONBlob(dir, manifestDesc, sub); err != nil {
+				return loaded, err
+			}
+			selected, err := selectManifest(sub.Manifests, hostPlatform())
+			if err != nil {
+				return loaded, err
+			}
+			manifestDesc = *selected
+		}
+
+		manifest := &Manifest{}
+		if err := readJSONBlob(dir, manifestDesc, manifest); err != nil {
+			return loaded, err
+		}
+		img, err := loadManifest(dir, manifest, name)
+		if err != nil {
+			return loaded, err
+		}
+		if name == "" {
+			name = img.Id
+		}
+		logger.Sugar().Infof("Loaded image %s %s", name, img.Id)
+		loaded = append(loaded, name)
+	}
+	return loaded, nil
+}
+
+// 根据manifest导入镜像配置和所有镜像层
+func loadManifest(dir string, manifest *Manifest, name string) (*Image, error) {
+	cfg := &ConfigFile{}
+	if err := readJSONBlob(dir, manifest.Config, cfg); err != nil {
+		return nil, err
+	}
+
+	var layers []string
+	for _, desc := range manifest.Layers {
+		fileName, err := blobPath(dir, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		id, err := importLayer(file, desc.Digest)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("import layer %s err %v", desc.Digest, err)
+		}
+		layers = append(layers, id)
+	}
+	return imageStore.Register(name, "", layers, configFromOCI(cfg))
+}
+
+// 从注解中获取镜像名
+// org.opencontainers.image.ref.name可能只包含tag，此时使用defaultName作为仓库名
+func refNameOf(annotations map[string]string, defaultName string) (string, error) {
+	if name := annotations[AnnotationImageName]; name != "" {
+		return name, nil
+	}
+	ref := annotations[AnnotationRefName]
+	if ref == "" || strings.ContainsAny(ref, ":/") {
+		if ref == "" {
+			return defaultName, nil
+		}
+		return ref, nil
+	}
+	if defaultName == "" {
+		return "", fmt.Errorf("archive only contains tag %s, please specify image name", ref)
+	}
+	// defaultName中的tag会被归档中的tag替换
+	repo := normalizeName(defaultName)
+	return repo[:strings.LastIndex(repo, ":")] + ":" + ref, nil
+}
+
+// 将镜像导出为OCI image-layout格式的tar包
+func SaveArchive(archive string, refs []string) error {
+	if err := os.MkdirAll(layerStore.Root, 0755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(layerStore.Root, "save-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	index := &Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex}
+	// 同一镜像层只打包一次
+	layerDescs := map[string]Descriptor{}
+	for _, ref := range refs {
+		img, err := imageStore.Get(ref)
+		if err != nil {
+			return err
+		}
+		manifestDesc, err := writeImageBlobs(dir, img, layerDescs)
+		if err != nil {
+			return err
+		}
+		// 使用镜像id导出时不记录镜像名
+		if !isIdRef(ref, img.Id) {
+			name := normalizeName(ref)
+			manifestDesc.Annotations = map[string]string{
+				AnnotationRefName:   name,
+				AnnotationImageName: name,
+			}
+		}
+		index.Manifests = append(index.Manifests, *manifestDesc)
+	}
+
+	layout, _ := json.Marshal(map[string]string{"imageLayoutVersion": OCILayoutVersion})
+	if err := os.WriteFile(path.Join(dir, OCILayoutFile), layout, 0644); err != nil {
+		return err
+	}
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dir, OCIIndexFile), b, 0644); err != nil {
+		return err
+	}
+
+	if output, err := exec.Command("tar", "-cf", archive, "-C", dir, ".").CombinedOutput(); err != nil {
+		return fmt.Errorf("error tar %s. %v %s", archive, err, output)
+	}
+	return nil
+}
+
+// 判断镜像引用是否为镜像id或其前缀
+func isIdRef(ref, id string) bool {
+	return isHex(digestHex(ref)) && strings.HasPrefix(digestHex(id), digestHex(ref))
+}
+
+// 将镜像的所有镜像层、配置和manifest写入OCI目录，返回manifest的描述符
+func writeImageBlobs(dir string, img *Image, layerDescs map[string]Descriptor) (*Descriptor, error) {
+	cfg := &ConfigFile{
+		Created:      ociTime(img.Created),
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		Config:       img.Config,
+		RootFS:       RootFS{Type: "layers"},
+	}
+	manifest := &Manifest{SchemaVersion: 2, MediaType: MediaTypeImageManifest}
+	for _, layer := range img.Layers {
+		desc, ok := layerDescs[layer]
+		if !ok {
+			var err error
+			if desc, err = writeLayerBlob(dir, layer); err != nil {
+				return nil, err
+			}
+			layerDescs[layer] = desc
+		}
+		cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, desc.Digest)
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+
+	configDesc, err := writeJSONBlob(dir, MediaTypeImageConfig, cfg)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Config = *configDesc
+	return writeJSONBlob(dir, MediaTypeImageManifest, manifest)
+}
+
+// 将镜像层重新打包为未压缩的tar，写入OCI目录
+func writeLayerBlob(dir string, layer string) (Descriptor, error) {
+	blobDir := path.Join(dir, "blobs", DigestAlgo)
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return Descriptor{}, err
+	}
+	tmpFile := path.Join(blobDir, "layer.tmp")
+	if err := WriteLayerTarFile(layerStore.Path(layer), tmpFile); err != nil {
+		return Descriptor{}, err
+	}
+	digest, err := fileDigest(tmpFile)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	info, err := os.Stat(tmpFile)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := os.Rename(tmpFile, path.Join(blobDir, digestHex(digest))); err != nil {
+		return Descriptor{}, err
+	}
+	return Descriptor{MediaType: MediaTypeImageLayer, Digest: digest, Size: info.Size()}, nil
+}
+
+// 将对象序列化为json并写入OCI目录
+func writeJSONBlob(dir string, mediaType string, v interface{}) (*Descriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	desc := &Descriptor{
+		MediaType: mediaType,
+		Digest:    DigestAlgo + ":" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(b)),
+	}
+	blobDir := path.Join(dir, "blobs", DigestAlgo)
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return nil, err
+	}
+	return desc, os.WriteFile(path.Join(blobDir, digestHex(desc.Digest)), b, 0644)
+}
+
+// 将镜像的创建时间转换为RFC3339格式
+func ociTime(created string) string {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", created, time.Local)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,69 @@
+package images
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func listImages() error {
+	summaries, err := imageStore.List()
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		name, id, size, created string
+	}
+	var rows []row
+	for _, img := range summaries {
+		names := img.Names
+		if len(names) == 0 {
+			names = []string{"<none>"}
+		}
+		for _, name := range names {
+			rows = append(rows, row{
+				name:    name,
+				id:      shortId(img.Id),
+				size:    humanSize(img.Size),
+				created: img.Created,
+			})
+		}
+	}
+
+	maxSize := map[string]int{"name": len("Name"), "id": len("Digest"), "size": len("Size")}
+	for _, r := range rows {
+		if maxSize["name"] < len(r.name) {
+			maxSize["name"] = len(r.name)
+		}
+		if maxSize["id"] < len(r.id) {
+			maxSize["id"] = len(r.id)
+		}
+		if maxSize["size"] < len(r.size) {
+			maxSize["size"] = len(r.size)
+		}
+	}
+
+	imageFormat := "%-" + strconv.Itoa(maxSize["name"]) + "s\t" +
+		"%-" + strconv.Itoa(maxSize["id"]) + "s\t" +
+		"%-" + strconv.Itoa(maxSize["size"]) + "s\t" +
+		"%s\n"
+	fmt.Fprintf(os.Stdout, imageFormat, "Name", "Digest", "Size", "Created")
+	for _, r := range rows {
+		fmt.Fprintf(os.Stdout, imageFormat, r.name, r.id, r.size, r.created)
+	}
+	return nil
+}
+
+// 将字节数转换为易读的格式
+func humanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(value, 'f', 2, 64), ".00") + units[i]
+}
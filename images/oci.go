@@ -0,0 +1,208 @@
+package images
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+const (
+	MediaTypeImageIndex     string = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest  string = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig    string = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayer     string = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeImageLayerGzip string = "application/vnd.oci.image.layer.v1.tar+gzip"
+	// docker的manifest格式与OCI格式兼容
+	MediaTypeDockerManifest     string = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList string = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       string = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayerGzip    string = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	// index.json中记录镜像名的注解
+	AnnotationRefName   string = "org.opencontainers.image.ref.name"
+	AnnotationImageName string = "io.containerd.image.name"
+	OCILayoutFile       string = "oci-layout"
+	OCIIndexFile        string = "index.json"
+	OCILayoutVersion    string = "1.0.0"
+)
+
+// OCI内容描述符
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// OCI镜像索引，也用于解析docker的manifest list
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// OCI镜像配置文件，config字段与miniker的镜像配置格式相同
+type ConfigFile struct {
+	Created      string       `json:"created,omitempty"`
+	Architecture string       `json:"architecture"`
+	OS           string       `json:"os"`
+	Config       *ImageConfig `json:"config,omitempty"`
+	RootFS       RootFS       `json:"rootfs"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// 当前主机的平台，用于从镜像索引中选择manifest
+func hostPlatform() Platform {
+	return Platform{Architecture: runtime.GOARCH, OS: runtime.GOOS}
+}
+
+// 解析os/arch[/variant]形式的平台
+func parsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("platform %q should be os/arch[/variant]", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// 判断描述符是否指向镜像索引
+func isIndex(mediaType string) bool {
+	return mediaType == MediaTypeImageIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// 从镜像索引中选择与平台匹配的manifest，没有平台信息的manifest视为匹配。
+// 平台没有指定variant时不比较variant
+func selectManifest(manifests []Descriptor, platform Platform) (*Descriptor, error) {
+	for i := range manifests {
+		p := manifests[i].Platform
+		if p == nil || (p.OS == platform.OS && p.Architecture == platform.Architecture &&
+			(platform.Variant == "" || p.Variant == platform.Variant)) {
+			return &manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no manifest for platform %s", platform)
+}
+
+// 根据OCI配置生成镜像配置
+func configFromOCI(cfg *ConfigFile) *ImageConfig {
+	if cfg == nil || cfg.Config == nil {
+		return &ImageConfig{}
+	}
+	return cfg.Config.Copy()
+}
+
+// 读取时计算摘要的Reader，读取完毕后可以校验摘要
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	h := sha256.New()
+	return &digestReader{r: io.TeeReader(r, h), hash: h}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	return d.r.Read(p)
+}
+
+func (d *digestReader) Digest() string {
+	return DigestAlgo + ":" + hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// 校验读取内容的摘要
+func (d *digestReader) Verify(expected string) error {
+	if !strings.HasPrefix(expected, DigestAlgo+":") {
+		return fmt.Errorf("unsupported digest %s", expected)
+	}
+	if actual := d.Digest(); actual != expected {
+		return fmt.Errorf("digest mismatch, expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// 校验数据的摘要
+func verifyContent(content []byte, expected string) error {
+	sum := sha256.Sum256(content)
+	if actual := DigestAlgo + ":" + hex.EncodeToString(sum[:]); actual != expected {
+		return fmt.Errorf("digest mismatch, expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// 将镜像层数据导入镜像层存储，自动识别gzip压缩
+// expected不为空时校验读取内容的摘要
+func importLayer(r io.Reader, expected string) (string, error) {
+	dr := newDigestReader(r)
+	br := bufio.NewReader(dr)
+	var layer io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		layer = gz
+	}
+
+	if err := os.MkdirAll(layerStore.Root, 0755); err != nil {
+		return "", err
+	}
+	tmpFile, err := os.CreateTemp(layerStore.Root, "layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := io.Copy(tmpFile, layer); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+	// 读取剩余数据，保证摘要覆盖完整内容
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return "", err
+	}
+	if expected != "" {
+		if err := dr.Verify(expected); err != nil {
+			return "", err
+		}
+	}
+	return layerStore.Extract(tmpFile.Name())
+}
@@ -0,0 +1,177 @@
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 拉取manifest时可以接受的格式
+var manifestAcceptTypes = []string{
+	MediaTypeImageManifest,
+	MediaTypeImageIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}
+
+// 从OCI distribution仓库拉取镜像，并以用户输入的名称存入镜像存储
+// 多平台镜像按platform选择manifest，格式为os/arch[/variant]
+func Pull(name string, plainHTTP bool, credentials string, platform string) (*Image, error) {
+	ref, err := parseReference(name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newRegistryClient(ref, plainHTTP, credentials)
+	if err != nil {
+		return nil, err
+	}
+	scope := fmt.Sprintf("repository:%s:pull", ref.Repository)
+
+	// 获取manifest，多平台镜像需要从索引中选出指定平台的manifest
+	content, mediaType, err := client.getManifest(ref.Repository, ref.identifier(), ref.Digest, scope)
+	if err != nil {
+		return nil, err
+	}
+	if isIndex(mediaType) {
+		index := &Index{}
+		if err := json.Unmarshal(content, index); err != nil {
+			return nil, err
+		}
+		desc, err := selectManifest(index.Manifests, p)
+		if err != nil {
+			return nil, err
+		}
+		if content, _, err = client.getManifest(ref.Repository, desc.Digest, desc.Digest, scope); err != nil {
+			return nil, err
+		}
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, err
+	}
+
+	// 获取镜像配置
+	configContent, err := client.getBlobContent(ref.Repository, manifest.Config.Digest, scope)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ConfigFile{}
+	if err := json.Unmarshal(configContent, cfg); err != nil {
+		return nil, err
+	}
+
+	// 下载并解压镜像层，本地已存在的层直接复用
+	var layers []string
+	for i, desc := range manifest.Layers {
+		if i < len(cfg.RootFS.DiffIDs) && layerStore.exists(cfg.RootFS.DiffIDs[i]) {
+			logger.Sugar().Infof("layer %s already exists", desc.Digest)
+			layers = append(layers, cfg.RootFS.DiffIDs[i])
+			continue
+		}
+		logger.Sugar().Infof("pulling layer %s", desc.Digest)
+		id, err := client.pullLayer(ref.Repository, desc.Digest, scope)
+		if err != nil {
+			return nil, fmt.Errorf("pull layer %s err %v", desc.Digest, err)
+		}
+		layers = append(layers, id)
+	}
+
+	localName := name
+	if ref.Digest != "" && ref.Tag == "" {
+		// 按摘要拉取时不设置镜像名
+		localName = ""
+	}
+	return imageStore.Register(localName, "", layers, configFromOCI(cfg))
+}
+
+// 获取manifest，expected不为空时校验内容的摘要
+func (c *registryClient) getManifest(repository, identifier, expected, scope string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("%s/manifests/%s", repository, identifier), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestAcceptTypes, ", "))
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, "", err
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	// 按tag拉取时没有预期的摘要，仓库返回了摘要则以其校验
+	if expected == "" && strings.HasPrefix(resp.Header.Get("Docker-Content-Digest"), DigestAlgo+":") {
+		expected = resp.Header.Get("Docker-Content-Digest")
+	}
+	if expected != "" {
+		if err := verifyContent(content, expected); err != nil {
+			return nil, "", err
+		}
+	}
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if mediaType == "" || mediaType == "application/json" {
+		// 部分仓库不返回准确的Content-Type，从内容中获取
+		var probe struct {
+			MediaType string       `json:"mediaType"`
+			Manifests []Descriptor `json:"manifests"`
+		}
+		json.Unmarshal(content, &probe)
+		mediaType = probe.MediaType
+		if mediaType == "" && probe.Manifests != nil {
+			mediaType = MediaTypeImageIndex
+		}
+	}
+	return content, mediaType, nil
+}
+
+// 获取blob
+func (c *registryClient) getBlob(repository, digest, scope string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("%s/blobs/%s", repository, digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 获取较小的blob并校验摘要，如镜像配置
+func (c *registryClient) getBlobContent(repository, digest, scope string) ([]byte, error) {
+	resp, err := c.getBlob(repository, digest, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return content, verifyContent(content, digest)
+}
+
+// 下载镜像层并导入镜像层存储，导入前会校验摘要
+func (c *registryClient) pullLayer(repository, digest, scope string) (string, error) {
+	resp, err := c.getBlob(repository, digest, scope)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return importLayer(resp.Body, digest)
+}
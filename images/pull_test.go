@@ -0,0 +1,159 @@
+package images
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// 读取manifest中的配置和镜像层摘要
+func (r *fakeRegistry) manifest(t *testing.T, desc Descriptor) *Manifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manifest := &Manifest{}
+	if err := json.Unmarshal(r.manifests[desc.Digest], manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+// 读取镜像唯一的镜像层中的文件
+func readLayerFile(t *testing.T, img *Image, name string) string {
+	t.Helper()
+	if len(img.Layers) != 1 {
+		t.Fatalf("image has %d layers, want 1", len(img.Layers))
+	}
+	content, err := os.ReadFile(layerStore.Path(img.Layers[0]) + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestPullSelectsPlatform(t *testing.T) {
+	r := newFakeRegistry(t)
+	r.auth = "bearer"
+	arm := r.addImage(t, "", "arm64", map[string]string{"arch": "arm64"})
+	amd := r.addImage(t, "", "amd64", map[string]string{"arch": "amd64"})
+	arm.Platform = &Platform{OS: "linux", Architecture: "arm64"}
+	amd.Platform = &Platform{OS: "linux", Architecture: "amd64"}
+	r.addManifest("latest", MediaTypeImageIndex, &Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageIndex,
+		Manifests:     []Descriptor{arm, amd},
+	})
+
+	tests := []struct {
+		platform string
+		want     string
+		wantErr  bool
+	}{
+		{platform: DefaultPlatform, want: "amd64"},
+		{platform: "linux/arm64", want: "arm64"},
+		{platform: "linux/s390x", wantErr: true},
+		{platform: "linux", wantErr: true},
+	}
+	for _, tt := range tests {
+		withTempStores(t)
+		img, err := Pull(r.host()+"/test/multi", false, "", tt.platform)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Pull with platform %s should fail", tt.platform)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Pull with platform %s err %v", tt.platform, err)
+		}
+		if got := readLayerFile(t, img, "arch"); got != tt.want {
+			t.Errorf("Pull with platform %s got layer of %s, want %s", tt.platform, got, tt.want)
+		}
+		if got, err := imageStore.Get(r.host() + "/test/multi:latest"); err != nil || got.Id != img.Id {
+			t.Errorf("image is not registered with its name, err %v", err)
+		}
+	}
+
+	for _, scope := range r.scopes {
+		if scope != "repository:test/multi:pull" {
+			t.Errorf("token scope = %q, want repository:test/multi:pull", scope)
+		}
+	}
+	if len(r.scopes) == 0 {
+		t.Errorf("token was never requested")
+	}
+}
+
+func TestPullByDigest(t *testing.T) {
+	withTempStores(t)
+	r := newFakeRegistry(t)
+	desc := r.addImage(t, "", "amd64", map[string]string{"hello": "world"})
+
+	img, err := Pull(r.host()+"/test/single@"+desc.Digest, false, "", DefaultPlatform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readLayerFile(t, img, "hello"); got != "world" {
+		t.Errorf("layer file = %q, want world", got)
+	}
+	if img.Config == nil || strings.Join(img.Config.Cmd, " ") != "sh" {
+		t.Errorf("image config = %+v, want Cmd [sh]", img.Config)
+	}
+}
+
+func TestPullDigestMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		byTag  bool
+		tamper func(r *fakeRegistry, desc Descriptor, manifest *Manifest)
+	}{
+		{
+			name:  "manifest digest header",
+			byTag: true,
+			tamper: func(r *fakeRegistry, desc Descriptor, manifest *Manifest) {
+				r.badDigestHeader["latest"] = DigestAlgo + ":" + strings.Repeat("0", 64)
+			},
+		},
+		{
+			name: "manifest by digest",
+			tamper: func(r *fakeRegistry, desc Descriptor, manifest *Manifest) {
+				r.manifests[desc.Digest] = append(r.manifests[desc.Digest], '\n')
+			},
+		},
+		{
+			name:  "config blob",
+			byTag: true,
+			tamper: func(r *fakeRegistry, desc Descriptor, manifest *Manifest) {
+				r.corruptBlobs[manifest.Config.Digest] = true
+			},
+		},
+		{
+			name:  "layer blob",
+			byTag: true,
+			tamper: func(r *fakeRegistry, desc Descriptor, manifest *Manifest) {
+				r.corruptBlobs[manifest.Layers[0].Digest] = true
+			},
+		},
+	}
+	for _, tt := range tests {
+		withTempStores(t)
+		r := newFakeRegistry(t)
+		desc := r.addImage(t, "latest", "amd64", map[string]string{"hello": "world"})
+		manifest := r.manifest(t, desc)
+		r.mu.Lock()
+		tt.tamper(r, desc, manifest)
+		r.mu.Unlock()
+
+		name := r.host() + "/test/single@" + desc.Digest
+		if tt.byTag {
+			name = r.host() + "/test/single:latest"
+		}
+		_, err := Pull(name, false, "", DefaultPlatform)
+		if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+			t.Errorf("%s: Pull err %v, want digest mismatch", tt.name, err)
+		}
+		if layers, _ := layerStore.List(); len(layers) > 0 {
+			t.Errorf("%s: layers %v were imported", tt.name, layers)
+		}
+	}
+}
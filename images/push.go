@@ -0,0 +1,239 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// 大于该值的blob使用分块上传
+var uploadChunkSize int64 = 16 << 20
+
+// 将本地镜像打包为OCI manifest和镜像层，推送到OCI distribution仓库
+// 返回manifest的摘要
+func Push(name string, plainHTTP bool, credentials string) (string, error) {
+	ref, err := parseReference(name)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return "", fmt.Errorf("cannot push by digest %s", name)
+	}
+	img, err := PrepareImage(name)
+	if err != nil {
+		return "", err
+	}
+	client, err := newRegistryClient(ref, plainHTTP, credentials)
+	if err != nil {
+		return "", err
+	}
+	scope := fmt.Sprintf("repository:%s:pull,push", ref.Repository)
+
+	// 先在临时目录中生成所有blob
+	if err := os.MkdirAll(layerStore.Root, 0755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(layerStore.Root, "push-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	manifestDesc, err := writeImageBlobs(dir, img, map[string]Descriptor{})
+	if err != nil {
+		return "", err
+	}
+	manifest := &Manifest{}
+	if err := readJSONBlob(dir, *manifestDesc, manifest); err != nil {
+		return "", err
+	}
+
+	// 上传镜像层和配置，仓库中已存在的blob不再上传
+	for _, desc := range append(append([]Descriptor(nil), manifest.Layers...), manifest.Config) {
+		exist, err := client.blobExists(ref.Repository, desc.Digest, scope)
+		if err != nil {
+			return "", err
+		}
+		if exist {
+			logger.Sugar().Infof("blob %s already exists", desc.Digest)
+			continue
+		}
+		fileName, err := blobPath(dir, desc.Digest)
+		if err != nil {
+			return "", err
+		}
+		logger.Sugar().Infof("pushing blob %s", desc.Digest)
+		if err := client.uploadBlob(ref.Repository, desc, fileName, scope); err != nil {
+			return "", fmt.Errorf("push blob %s err %v", desc.Digest, err)
+		}
+	}
+
+	// 最后上传manifest
+	manifestFile, err := blobPath(dir, manifestDesc.Digest)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return "", err
+	}
+	if err := client.putManifest(ref.Repository, ref.Tag, manifestDesc.MediaType, content, scope); err != nil {
+		return "", err
+	}
+	return manifestDesc.Digest, nil
+}
+
+// 检查仓库中是否已存在blob
+func (c *registryClient) blobExists(repository, digest, scope string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.url("%s/blobs/%s", repository, digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, checkResponse(resp, http.StatusOK)
+}
+
+// 上传blob，小文件一次性上传，大文件分块上传
+func (c *registryClient) uploadBlob(repository string, desc Descriptor, fileName string, scope string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 开启上传会话
+	req, err := http.NewRequest(http.MethodPost, c.url("%s/blobs/uploads/", repository), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if err := checkResponse(resp, http.StatusAccepted); err != nil {
+		return err
+	}
+	location, err := c.resolveLocation(resp)
+	if err != nil {
+		return err
+	}
+
+	if desc.Size > uploadChunkSize {
+		for offset := int64(0); offset < desc.Size; offset += uploadChunkSize {
+			size := uploadChunkSize
+			if offset+size > desc.Size {
+				size = desc.Size - offset
+			}
+			req, err := newSectionRequest(http.MethodPatch, location, file, offset, size)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+size-1))
+			resp, err := c.do(req, scope)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if err := checkResponse(resp, http.StatusAccepted, http.StatusNoContent); err != nil {
+				return err
+			}
+			if location, err = c.resolveLocation(resp); err != nil {
+				return err
+			}
+		}
+		// 所有分块上传完成后，使用摘要结束上传会话
+		req, err := http.NewRequest(http.MethodPut, withDigest(location, desc.Digest), nil)
+		if err != nil {
+			return err
+		}
+		return c.finishUpload(req, scope)
+	}
+
+	req, err = newSectionRequest(http.MethodPut, withDigest(location, desc.Digest), file, 0, desc.Size)
+	if err != nil {
+		return err
+	}
+	return c.finishUpload(req, scope)
+}
+
+func (c *registryClient) finishUpload(req *http.Request, scope string) error {
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusCreated)
+}
+
+// 创建以文件的一部分为请求体的请求，认证后重试时可以重新读取
+func newSectionRequest(method, location string, file *os.File, offset, size int64) (*http.Request, error) {
+	req, err := http.NewRequest(method, location, io.NewSectionReader(file, offset, size))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(file, offset, size)), nil
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	return req, nil
+}
+
+// 获取上传会话的地址，Location可能是相对路径
+func (c *registryClient) resolveLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("missing upload location from %s", resp.Request.URL)
+	}
+	base, err := url.Parse(c.url(""))
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// 在上传地址中增加digest参数
+func withDigest(location, digest string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// 上传manifest
+func (c *registryClient) putManifest(repository, tag, mediaType string, content []byte, scope string) error {
+	req, err := http.NewRequest(http.MethodPut, c.url("%s/manifests/%s", repository, tag), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusCreated)
+}
@@ -0,0 +1,175 @@
+package images
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+)
+
+// 在本地镜像存储中创建要推送的镜像
+func newPushImage(t *testing.T, name string) *Image {
+	t.Helper()
+	layer := newTestLayer(t, map[string]string{"hello": "world"})
+	img, err := imageStore.Register(name, "", []string{layer}, &ImageConfig{Cmd: []string{"sh"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// 写入只包含一个仓库认证信息的auth.json
+func writeCredentials(t *testing.T, registry string, auth registryAuth) string {
+	t.Helper()
+	content, err := json.Marshal(&credentialsFile{Auths: map[string]registryAuth{registry: auth}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := path.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(fileName, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestPushWithToken(t *testing.T) {
+	withTempStores(t)
+	r := newFakeRegistry(t)
+	r.auth = "bearer"
+	r.username, r.password = "alice", "secret"
+	credentials := writeCredentials(t, r.host(), registryAuth{Auth: base64.StdEncoding.EncodeToString([]byte("alice:secret"))})
+	name := r.host() + "/test/push:v1"
+	newPushImage(t, name)
+
+	digest, err := Push(name, false, credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, ok := r.manifests["v1"]
+	if !ok {
+		t.Fatalf("manifest v1 was not uploaded")
+	}
+	if got := digestOf(content); got != digest {
+		t.Errorf("Push() = %s, uploaded manifest digest %s", digest, got)
+	}
+	if r.mediaTypes["v1"] != MediaTypeImageManifest {
+		t.Errorf("manifest media type = %s, want %s", r.mediaTypes["v1"], MediaTypeImageManifest)
+	}
+	// 镜像层和配置都小于分块大小，各使用一次PUT上传
+	if r.patches != 0 || r.puts != 2 {
+		t.Errorf("uploads used %d PATCH and %d PUT, want 0 and 2", r.patches, r.puts)
+	}
+	for _, scope := range r.scopes {
+		if scope != "repository:test/push:pull,push" {
+			t.Errorf("token scope = %q, want repository:test/push:pull,push", scope)
+		}
+	}
+
+	// 推送的镜像可以再拉取下来
+	withTempStores(t)
+	img, err := Pull(name, false, credentials, DefaultPlatform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readLayerFile(t, img, "hello"); got != "world" {
+		t.Errorf("pulled layer file = %q, want world", got)
+	}
+}
+
+func TestPushSkipsExistingBlobs(t *testing.T) {
+	withTempStores(t)
+	r := newFakeRegistry(t)
+	name := r.host() + "/test/push:v1"
+	newPushImage(t, name)
+
+	if _, err := Push(name, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	heads, puts := r.heads, r.puts
+	if _, err := Push(name, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if r.heads != heads+2 {
+		t.Errorf("second push sent %d HEAD requests, want 2", r.heads-heads)
+	}
+	if r.puts != puts || r.sessions != puts {
+		t.Errorf("second push uploaded %d blobs, want 0", r.puts-puts)
+	}
+}
+
+func TestPushChunked(t *testing.T) {
+	withTempStores(t)
+	old := uploadChunkSize
+	uploadChunkSize = 512
+	t.Cleanup(func() {
+		uploadChunkSize = old
+	})
+	r := newFakeRegistry(t)
+	name := r.host() + "/test/push:v1"
+	newPushImage(t, name)
+
+	digest, err := Push(name, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := r.manifest(t, Descriptor{Digest: digest})
+	layer := manifest.Layers[0]
+	if _, ok := r.blobs[layer.Digest]; !ok {
+		t.Fatalf("layer %s was not uploaded", layer.Digest)
+	}
+	// 镜像层分块上传，配置小于分块大小，一次上传
+	want := int((layer.Size + uploadChunkSize - 1) / uploadChunkSize)
+	if manifest.Config.Size > uploadChunkSize {
+		t.Fatalf("config size %d is larger than chunk size", manifest.Config.Size)
+	}
+	if r.patches != want {
+		t.Errorf("layer of %d bytes uploaded in %d chunks, want %d", layer.Size, r.patches, want)
+	}
+	if r.puts != 2 {
+		t.Errorf("uploads finished with %d PUT, want 2", r.puts)
+	}
+}
+
+func TestPushBasicCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    *registryAuth
+		wantErr bool
+	}{
+		{name: "auth", auth: &registryAuth{Auth: base64.StdEncoding.EncodeToString([]byte("alice:secret"))}},
+		{name: "username and password", auth: &registryAuth{Username: "alice", Password: "secret"}},
+		{name: "wrong password", auth: &registryAuth{Username: "alice", Password: "wrong"}, wantErr: true},
+		{name: "no credentials", wantErr: true},
+	}
+	for _, tt := range tests {
+		withTempStores(t)
+		r := newFakeRegistry(t)
+		r.auth = "basic"
+		r.username, r.password = "alice", "secret"
+		credentials := path.Join(t.TempDir(), "missing.json")
+		if tt.auth != nil {
+			credentials = writeCredentials(t, r.host(), *tt.auth)
+		}
+		name := r.host() + "/test/push:v1"
+		newPushImage(t, name)
+
+		_, err := Push(name, false, credentials)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: Push should fail", tt.name)
+			}
+			if _, ok := r.manifests["v1"]; ok {
+				t.Errorf("%s: manifest was uploaded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Push err %v", tt.name, err)
+			continue
+		}
+		if _, ok := r.manifests["v1"]; !ok {
+			t.Errorf("%s: manifest was not uploaded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package images
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// 镜像名中没有仓库地址时使用docker hub
+	DefaultRegistry    string = "docker.io"
+	DefaultRegistryAPI string = "registry-1.docker.io"
+)
+
+// 镜像引用，格式为[registry/]repository[:tag][@digest]
+type reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// 解析镜像引用
+func parseReference(ref string) (*reference, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("empty image reference")
+	}
+	r := &reference{}
+	if i := strings.Index(ref, "@"); i >= 0 {
+		r.Digest = ref[i+1:]
+		ref = ref[:i]
+		if !strings.HasPrefix(r.Digest, DigestAlgo+":") || !isHex(digestHex(r.Digest)) {
+			return nil, fmt.Errorf("invalid digest %s", r.Digest)
+		}
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		r.Tag = ref[i+1:]
+		ref = ref[:i]
+	}
+
+	// 第一段包含'.'或':'，或者是localhost时，认为是仓库地址
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.Registry = parts[0]
+		r.Repository = parts[1]
+	} else {
+		r.Registry = DefaultRegistry
+		r.Repository = ref
+	}
+	if r.Registry == DefaultRegistry && !strings.Contains(r.Repository, "/") {
+		r.Repository = "library/" + r.Repository
+	}
+	if r.Repository == "" {
+		return nil, fmt.Errorf("invalid image reference %s", ref)
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = DefaultTag
+	}
+	return r, nil
+}
+
+// 获取仓库API的地址
+func (r *reference) apiHost() string {
+	if r.Registry == DefaultRegistry {
+		return DefaultRegistryAPI
+	}
+	return r.Registry
+}
+
+// 获取manifest接口中使用的tag或摘要
+func (r *reference) identifier() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r *reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
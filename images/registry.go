@@ -0,0 +1,206 @@
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OCI distribution仓库的客户端
+type registryClient struct {
+	host   string
+	scheme string
+	// 仓库的认证信息，为空时匿名访问
+	username string
+	password string
+	// 通过token认证获取的Bearer token
+	token  string
+	client *http.Client
+}
+
+// 创建仓库客户端，本地仓库默认使用http，认证信息从credentials文件中读取
+func newRegistryClient(ref *reference, plainHTTP bool, credentials string) (*registryClient, error) {
+	host := ref.apiHost()
+	scheme := "https"
+	if plainHTTP || isLocalRegistry(host) {
+		scheme = "http"
+	}
+	username, password, err := loadCredentials(credentials, ref.Registry)
+	if err != nil {
+		return nil, err
+	}
+	return &registryClient{
+		host:     host,
+		scheme:   scheme,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+func isLocalRegistry(host string) bool {
+	hostname := strings.Split(host, ":")[0]
+	return hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1"
+}
+
+func (c *registryClient) url(format string, args ...interface{}) string {
+	return fmt.Sprintf("%s://%s/v2/", c.scheme, c.host) + fmt.Sprintf(format, args...)
+}
+
+// 发送请求，收到401时根据WWW-Authenticate完成认证后重试一次
+func (c *registryClient) do(req *http.Request, scope string) (*http.Response, error) {
+	c.authorize(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := c.authenticate(challenge, scope); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("cannot retry %s %s after authentication", req.Method, req.URL)
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	c.authorize(retry)
+	return c.client.Do(retry)
+}
+
+func (c *registryClient) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// 根据仓库返回的认证质询获取凭证
+func (c *registryClient) authenticate(challenge string, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("registry %s requires basic auth", c.host)
+		}
+		return nil
+	case "bearer":
+		return c.fetchToken(params, scope)
+	default:
+		return fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+}
+
+// 从认证服务获取token
+func (c *registryClient) fetchToken(params map[string]string, scope string) error {
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("missing realm in auth challenge")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope == "" {
+		scope = params["scope"]
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get token from %s err %s", realm, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("empty token from %s", realm)
+	}
+	return nil
+}
+
+// 解析WWW-Authenticate，如 Bearer realm="https://auth",service="registry",scope="..."
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return parts[0], params
+}
+
+// 检查响应状态码
+func checkResponse(resp *http.Response, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,286 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 测试用的OCI distribution仓库，在内存中保存manifest和blob
+type fakeRegistry struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu sync.Mutex
+	// 按tag或摘要保存的manifest及其格式
+	manifests  map[string][]byte
+	mediaTypes map[string]string
+	blobs      map[string][]byte
+	// 返回错误的Docker-Content-Digest或被篡改的blob，用于测试摘要校验
+	badDigestHeader map[string]string
+	corruptBlobs    map[string]bool
+
+	// 认证方式，为空时匿名访问，可以是basic或bearer
+	auth     string
+	username string
+	password string
+	token    string
+	// 认证服务收到的scope
+	scopes []string
+
+	// 上传会话中已收到的数据
+	uploads map[string][]byte
+	// 各类请求的次数
+	heads    int
+	sessions int
+	patches  int
+	puts     int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		t:               t,
+		manifests:       map[string][]byte{},
+		mediaTypes:      map[string]string{},
+		blobs:           map[string][]byte{},
+		badDigestHeader: map[string]string{},
+		corruptBlobs:    map[string]bool{},
+		uploads:         map[string][]byte{},
+		token:           "fake-token",
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// 仓库地址，127.0.0.1使用http访问
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return DigestAlgo + ":" + hex.EncodeToString(sum[:])
+}
+
+func (r *fakeRegistry) addBlob(content []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf(content)
+	r.blobs[digest] = content
+	return digest
+}
+
+// 保存manifest，tag不为空时同时以tag保存
+func (r *fakeRegistry) addManifest(tag, mediaType string, v interface{}) Descriptor {
+	content, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf(content)
+	for _, key := range []string{digest, tag} {
+		if key != "" {
+			r.manifests[key] = content
+			r.mediaTypes[key] = mediaType
+		}
+	}
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content))}
+}
+
+// 上传一个只有一层的镜像，返回其manifest的描述符
+func (r *fakeRegistry) addImage(t *testing.T, tag string, arch string, files map[string]string) Descriptor {
+	layer := tarBytes(t, files)
+	layerDigest := r.addBlob(layer)
+	config, err := json.Marshal(&ConfigFile{
+		Architecture: arch,
+		OS:           "linux",
+		Config:       &ImageConfig{Cmd: []string{"sh"}},
+		RootFS:       RootFS{Type: "layers", DiffIDs: []string{layerDigest}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configDigest := r.addBlob(config)
+	return r.addManifest(tag, MediaTypeImageManifest, &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        Descriptor{MediaType: MediaTypeImageConfig, Digest: configDigest, Size: int64(len(config))},
+		Layers:        []Descriptor{{MediaType: MediaTypeImageLayer, Digest: layerDigest, Size: int64(len(layer))}},
+	})
+}
+
+// 检查请求的认证信息，未通过时返回质询
+func (r *fakeRegistry) authorized(w http.ResponseWriter, req *http.Request) bool {
+	switch r.auth {
+	case "basic":
+		if username, password, ok := req.BasicAuth(); ok && username == r.username && password == r.password {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+	case "bearer":
+		if req.Header.Get("Authorization") == "Bearer "+r.token {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.server.URL))
+	default:
+		return true
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+// 认证服务，配置了用户名时要求basic认证
+func (r *fakeRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if r.username != "" {
+		if username, password, ok := req.BasicAuth(); !ok || username != r.username || password != r.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	r.mu.Lock()
+	r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+	r.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+}
+
+func (r *fakeRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	p := req.URL.Path
+	if p == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if !r.authorized(w, req) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case strings.Contains(p, "/blobs/uploads/"):
+		r.serveUpload(w, req, p)
+	case strings.Contains(p, "/manifests/") && req.Method == http.MethodPut:
+		r.putManifest(w, req, p[strings.LastIndex(p, "/")+1:])
+	case strings.Contains(p, "/manifests/"):
+		r.serveManifest(w, req, p[strings.LastIndex(p, "/")+1:])
+	case strings.Contains(p, "/blobs/"):
+		r.serveBlob(w, req, p[strings.LastIndex(p, "/")+1:])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, ref string) {
+	content, ok := r.manifests[ref]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	digest := digestOf(content)
+	if bad, ok := r.badDigestHeader[ref]; ok {
+		digest = bad
+	}
+	w.Header().Set("Content-Type", r.mediaTypes[ref])
+	w.Header().Set("Docker-Content-Digest", digest)
+	if req.Method != http.MethodHead {
+		w.Write(content)
+	}
+}
+
+func (r *fakeRegistry) serveBlob(w http.ResponseWriter, req *http.Request, digest string) {
+	if req.Method == http.MethodHead {
+		r.heads++
+	}
+	content, ok := r.blobs[digest]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.corruptBlobs[digest] {
+		content = append(append([]byte(nil), content...), '\n')
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+	if req.Method != http.MethodHead {
+		w.Write(content)
+	}
+}
+
+// 上传blob：POST开启会话，PATCH追加分块，PUT附带digest结束会话
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, p string) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch req.Method {
+	case http.MethodPost:
+		r.sessions++
+		id := fmt.Sprintf("upload-%d", r.sessions)
+		r.uploads[id] = nil
+		// 使用相对地址，客户端需要自己补全
+		w.Header().Set("Location", p+id)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	id := p[strings.LastIndex(p, "/")+1:]
+	data, ok := r.uploads[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	data = append(data, body...)
+	r.uploads[id] = data
+	switch req.Method {
+	case http.MethodPatch:
+		r.patches++
+		w.Header().Set("Location", p)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		r.puts++
+		digest := req.URL.Query().Get("digest")
+		if digestOf(data) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[digest] = data
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// 保存上传的manifest，引用的blob必须已经存在
+func (r *fakeRegistry) putManifest(w http.ResponseWriter, req *http.Request, tag string) {
+	content, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, desc := range append(manifest.Layers, manifest.Config) {
+		if _, ok := r.blobs[desc.Digest]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	digest := digestOf(content)
+	for _, key := range []string{digest, tag} {
+		r.manifests[key] = content
+		r.mediaTypes[key] = req.Header.Get("Content-Type")
+	}
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
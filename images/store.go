@@ -0,0 +1,471 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 镜像信息
+type Image struct {
+	Id string `json:"id"`
+	// 父镜像id，由commit生成的镜像在父镜像的基础上增加一层
+	Parent string `json:"parent,omitempty"`
+	// 镜像层id，按从下到上的顺序排列
+	Layers  []string     `json:"layers"`
+	Config  *ImageConfig `json:"config"`
+	Created string       `json:"created"`
+	Size    int64        `json:"size"`
+}
+
+// 镜像存储，记录镜像名到镜像id的映射以及每个镜像的信息
+type ImageStore struct {
+	Root string
+	// 镜像名到镜像id的映射，镜像名的格式为name:tag
+	Repositories map[string]string
+}
+
+// 默认的镜像存储
+var imageStore = &ImageStore{
+	Root: DefaultImagePath,
+}
+
+// 补全镜像名中的tag
+func normalizeName(name string) string {
+	// 最后一个'/'之后的':'才是tag的分隔符，之前的可能是仓库地址的端口
+	if !strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		return name + ":" + DefaultTag
+	}
+	return name
+}
+
+// 获取镜像id中用于展示的短id
+func shortId(id string) string {
+	hex := digestHex(id)
+	if len(hex) > 12 {
+		return hex[:12]
+	}
+	return hex
+}
+
+func (s *ImageStore) lock() (func(), error) {
+	unlock, err := lockDir(s.Root)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// 从文件中加载镜像名的映射
+func (s *ImageStore) load() error {
+	s.Repositories = map[string]string{}
+	content, err := os.ReadFile(path.Join(s.Root, RepositoriesName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(content, &s.Repositories)
+}
+
+// 将镜像名的映射存储到文件
+func (s *ImageStore) dump() error {
+	b, err := json.Marshal(s.Repositories)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.Root, RepositoriesName), b, 0644)
+}
+
+func (s *ImageStore) imagePath(id string) string {
+	return path.Join(s.Root, digestHex(id)+".json")
+}
+
+func (s *ImageStore) loadImage(id string) (*Image, error) {
+	content, err := os.ReadFile(s.imagePath(id))
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{}
+	if err := json.Unmarshal(content, img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func (s *ImageStore) dumpImage(img *Image) error {
+	b, err := json.Marshal(img)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.imagePath(img.Id), b, 0644)
+}
+
+// 解析镜像引用，可以是镜像名，也可以是镜像id或其前缀
+func (s *ImageStore) resolve(ref string) (string, error) {
+	if id, ok := s.Repositories[normalizeName(ref)]; ok {
+		return id, nil
+	}
+	if !isHex(digestHex(ref)) {
+		return "", fmt.Errorf("no such image %s", ref)
+	}
+	ids, err := s.imageIds()
+	if err != nil {
+		return "", err
+	}
+	var matched []string
+	for _, id := range ids {
+		if id == ref || strings.HasPrefix(digestHex(id), digestHex(ref)) {
+			matched = append(matched, id)
+		}
+	}
+	if len(matched) == 1 {
+		return matched[0], nil
+	}
+	if len(matched) > 1 {
+		return "", fmt.Errorf("ambiguous image id %s", ref)
+	}
+	return "", fmt.Errorf("no such image %s", ref)
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// 列出所有镜像id
+func (s *ImageStore) imageIds() ([]string, error) {
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == RepositoriesName || name == BuildCacheName || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		ids = append(ids, DigestAlgo+":"+strings.TrimSuffix(name, ".json"))
+	}
+	return ids, nil
+}
+
+// 获取镜像的所有名称
+func (s *ImageStore) namesOf(id string) []string {
+	var names []string
+	for name, imageId := range s.Repositories {
+		if imageId == id {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 根据镜像层和运行配置计算镜像id
+func imageId(parent string, layers []string, config *ImageConfig) (string, error) {
+	b, err := json.Marshal(struct {
+		Parent string       `json:"parent,omitempty"`
+		Layers []string     `json:"layers"`
+		Config *ImageConfig `json:"config"`
+	}{parent, layers, config})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return DigestAlgo + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// 注册镜像并设置镜像名，镜像名已存在时指向新的镜像
+func (s *ImageStore) Register(name string, parent string, layers []string, config *ImageConfig) (*Image, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if config == nil {
+		config = &ImageConfig{}
+	}
+	id, err := imageId(parent, layers, config)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.loadImage(id)
+	if err != nil {
+		img = &Image{
+			Id:      id,
+			Parent:  parent,
+			Layers:  layers,
+			Config:  config,
+			Created: time.Now().Format("2006-01-02 15:04:05"),
+		}
+		for _, layer := range layers {
+			img.Size += layerStore.Size(layer)
+		}
+		if err := s.dumpImage(img); err != nil {
+			return nil, err
+		}
+	}
+
+	if name != "" {
+		s.Repositories[normalizeName(name)] = id
+		if err := s.dump(); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// 获取镜像信息
+func (s *ImageStore) Get(ref string) (*Image, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	id, err := s.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.loadImage(id)
+}
+
+// 为镜像添加新的名称
+func (s *ImageStore) Tag(source, target string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	id, err := s.resolve(source)
+	if err != nil {
+		return err
+	}
+	s.Repositories[normalizeName(target)] = id
+	return s.dump()
+}
+
+// 删除镜像名，镜像没有其他名称时一并删除镜像和不再使用的镜像层
+func (s *ImageStore) Remove(ref string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	id, err := s.resolve(ref)
+	if err != nil {
+		return err
+	}
+
+	name := normalizeName(ref)
+	if _, ok := s.Repositories[name]; ok && len(s.namesOf(id)) > 1 {
+		// 镜像还有其他名称，只删除当前名称
+		delete(s.Repositories, name)
+		return s.dump()
+	}
+
+	img, err := s.loadImage(id)
+	if err != nil {
+		return err
+	}
+	// 只检查会被删除的镜像层，与其他镜像共用的层不会被删除
+	own, err := s.ownLayers(img)
+	if err != nil {
+		return err
+	}
+	if users := layersInUse(own); len(users) > 0 {
+		return fmt.Errorf("image %s is being used by container %s", ref, strings.Join(users, ","))
+	}
+	for _, n := range s.namesOf(id) {
+		delete(s.Repositories, n)
+	}
+	if err := s.dump(); err != nil {
+		return err
+	}
+	return s.removeImage(img)
+}
+
+// 删除镜像信息和只被该镜像使用的镜像层
+func (s *ImageStore) removeImage(img *Image) error {
+	own, err := s.ownLayers(img)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.imagePath(img.Id)); err != nil {
+		return err
+	}
+	for _, layer := range own {
+		if err := layerStore.Remove(layer); err != nil {
+			logger.Sugar().Warnf("remove layer %s err %v", layer, err)
+		}
+	}
+	logger.Sugar().Infof("Deleted image %s", img.Id)
+	return nil
+}
+
+// 获取所有镜像使用的镜像层
+func (s *ImageStore) usedLayers() (map[string]bool, error) {
+	ids, err := s.imageIds()
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, id := range ids {
+		img, err := s.loadImage(id)
+		if err != nil {
+			continue
+		}
+		for _, layer := range img.Layers {
+			used[layer] = true
+		}
+	}
+	return used, nil
+}
+
+// 获取只被该镜像使用的镜像层，即删除镜像时会一起删除的层
+func (s *ImageStore) ownLayers(img *Image) ([]string, error) {
+	ids, err := s.imageIds()
+	if err != nil {
+		return nil, err
+	}
+	shared := map[string]bool{}
+	for _, id := range ids {
+		if id == img.Id {
+			continue
+		}
+		other, err := s.loadImage(id)
+		if err != nil {
+			continue
+		}
+		for _, layer := range other.Layers {
+			shared[layer] = true
+		}
+	}
+	var own []string
+	for _, layer := range img.Layers {
+		if !shared[layer] {
+			own = append(own, layer)
+		}
+	}
+	return own, nil
+}
+
+// 获取正在使用镜像层的容器
+func layersInUse(layers []string) []string {
+	var users []string
+	for _, layer := range layers {
+		users = append(users, layerStore.Refs(layer)...)
+	}
+	return users
+}
+
+// 镜像及其名称
+type ImageSummary struct {
+	Image
+	Names []string
+}
+
+// 列出所有镜像
+func (s *ImageStore) List() ([]*ImageSummary, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ids, err := s.imageIds()
+	if err != nil {
+		return nil, err
+	}
+	var summaries []*ImageSummary
+	for _, id := range ids {
+		img, err := s.loadImage(id)
+		if err != nil {
+			logger.Sugar().Errorf("load image %s err %v", id, err)
+			continue
+		}
+		summaries = append(summaries, &ImageSummary{Image: *img, Names: s.namesOf(id)})
+	}
+	return summaries, nil
+}
+
+// 删除没有名称且未被容器使用的镜像，以及不属于任何镜像的镜像层
+func (s *ImageStore) Prune() ([]string, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ids, err := s.imageIds()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, id := range ids {
+		if len(s.namesOf(id)) > 0 {
+			continue
+		}
+		img, err := s.loadImage(id)
+		if err != nil {
+			continue
+		}
+		own, err := s.ownLayers(img)
+		if err != nil {
+			return pruned, err
+		}
+		if len(layersInUse(own)) > 0 {
+			continue
+		}
+		if err := s.removeImage(img); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, id)
+	}
+
+	// 清理残留的镜像层
+	used, err := s.usedLayers()
+	if err != nil {
+		return pruned, err
+	}
+	layers, err := layerStore.List()
+	if err != nil {
+		return pruned, err
+	}
+	for _, layer := range layers {
+		if used[layer] || len(layerStore.Refs(layer)) > 0 {
+			continue
+		}
+		if err := layerStore.Remove(layer); err != nil {
+			logger.Sugar().Warnf("remove layer %s err %v", layer, err)
+			continue
+		}
+		pruned = append(pruned, layer)
+	}
+	return pruned, nil
+}
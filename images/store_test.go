@@ -0,0 +1,114 @@
+package images
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path"
+	"testing"
+)
+
+// 使用临时目录中的镜像存储和镜像层存储
+func withTempStores(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	oldImages, oldLayers := imageStore, layerStore
+	imageStore = &ImageStore{Root: path.Join(dir, "images")}
+	layerStore = &LayerStore{Root: path.Join(dir, "layers")}
+	t.Cleanup(func() {
+		imageStore, layerStore = oldImages, oldLayers
+	})
+}
+
+// 生成包含指定文件的tar包内容
+func tarBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 创建包含指定文件的镜像层
+func newTestLayer(t *testing.T, files map[string]string) string {
+	t.Helper()
+	tarFile := path.Join(t.TempDir(), "layer.tar")
+	if err := os.WriteFile(tarFile, tarBytes(t, files), 0644); err != nil {
+		t.Fatal(err)
+	}
+	id, err := layerStore.Extract(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestRemoveIgnoresSharedLayersInUse(t *testing.T) {
+	withTempStores(t)
+	base := newTestLayer(t, map[string]string{"base": "base"})
+	top := newTestLayer(t, map[string]string{"top": "top"})
+	parent, err := imageStore.Register("parent", "", []string{base}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imageStore.Register("child", parent.Id, []string{base, top}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 容器使用父镜像时，子镜像仍然可以删除，共用的层保留
+	if err := layerStore.Acquire(base, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := imageStore.Remove("child"); err != nil {
+		t.Fatalf("Remove(child) err %v", err)
+	}
+	if !layerStore.exists(base) {
+		t.Errorf("shared layer %s was removed", base)
+	}
+	if layerStore.exists(top) {
+		t.Errorf("layer %s of child was not removed", top)
+	}
+
+	// 只属于该镜像的层被容器使用时拒绝删除
+	if err := imageStore.Remove("parent"); err == nil {
+		t.Errorf("Remove(parent) should fail while c1 uses its layer")
+	}
+}
+
+func TestPruneIgnoresSharedLayersInUse(t *testing.T) {
+	withTempStores(t)
+	base := newTestLayer(t, map[string]string{"base": "base"})
+	top := newTestLayer(t, map[string]string{"top": "top"})
+	parent, err := imageStore.Register("parent", "", []string{base}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := imageStore.Register("", parent.Id, []string{base, top}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layerStore.Acquire(base, "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := imageStore.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0] != child.Id {
+		t.Errorf("Prune() = %v, want [%s]", pruned, child.Id)
+	}
+	if !layerStore.exists(base) || layerStore.exists(top) {
+		t.Errorf("Prune() left layers base=%v top=%v, want base only", layerStore.exists(base), layerStore.exists(top))
+	}
+}
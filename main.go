@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"miniker/containers"
+	"miniker/images"
 	"miniker/networks"
 	"os"
 
@@ -13,19 +14,51 @@ func main() {
 	app := &cli.App{
 		Name:  "miniker",
 		Usage: "Simple docker runtime",
+		Flags: []cli.Flag{
+			containers.NewStorageDriverFlag(),
+		},
+		Before: func(ctx *cli.Context) error {
+			if needReconcile(ctx.Args()) {
+				networks.Reconcile()
+			}
+			return nil
+		},
 		Commands: []*cli.Command{
 			containers.NewRunCommand(),
 			containers.NewInitCommand(),
 			containers.NewCommitCommand(),
 			containers.NewPsCommand(),
+			containers.NewInspectCommand(),
 			containers.NewLogsCommand(),
 			containers.NewExecCommand(),
 			containers.NewStopCommand(),
 			containers.NewRemoveCommand(),
-			networks.NewNetworkCommand(),
+			containers.NewBuildCommand(),
+			networks.NewNetworkCommand(
+				containers.NewNetworkConnectCommand(),
+				containers.NewNetworkDisconnectCommand(),
+			),
+			images.NewImageCommand(),
+			images.NewPullCommand(),
+			images.NewPushCommand(),
 		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// 会使用或修改网络的命令在执行前检查网络状态，其余命令不修改宿主机的网络。
+// 容器的init进程、网络的dns服务和端口代理也不检查网络
+func needReconcile(args cli.Args) bool {
+	switch args.First() {
+	case "run", "build":
+		return true
+	case "network":
+		switch args.Get(1) {
+		case "create", "rm", "connect", "disconnect":
+			return true
+		}
+	}
+	return false
+}
@@ -6,15 +6,20 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
-func NewNetworkCommand() *cli.Command {
+// 创建network命令，containerCommands为需要访问容器信息的子命令，由containers包提供
+func NewNetworkCommand(containerCommands ...*cli.Command) *cli.Command {
 	return &cli.Command{
 		Name:  "network",
 		Usage: "miniker network COMMAND",
-		Subcommands: []*cli.Command{
+		Subcommands: append([]*cli.Command{
 			NewListCommand(),
 			NewCreateCommand(),
 			NewRemoveCommand(),
-		},
+			NewInspectCommand(),
+			NewReconcileCommand(),
+			NewDNSCommand(),
+			NewProxyCommand(),
+		}, containerCommands...),
 	}
 }
 
@@ -69,3 +74,57 @@ func NewRemoveCommand() *cli.Command {
 		},
 	}
 }
+
+func NewInspectCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "inspect",
+		Usage: "Display detailed information on a network",
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input network name")
+			}
+			return inspectNetwork(ctx.Args().Get(0))
+		},
+	}
+}
+
+func NewReconcileCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "reconcile",
+		Usage: "Restore bridges and NAT rules of networks and release stale endpoints",
+		Action: func(ctx *cli.Context) error {
+			Reconcile()
+			return nil
+		},
+	}
+}
+
+// 运行网络的dns服务，由连接网络的容器在后台启动
+func NewDNSCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "dns",
+		Usage:  "Run the embedded DNS server of a network",
+		Hidden: true,
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 1 {
+				return errors.New("please input network name")
+			}
+			return ServeDNS(ctx.Args().Get(0))
+		},
+	}
+}
+
+// 运行端口映射的代理，由连接网络的容器在后台启动
+func NewProxyCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "proxy",
+		Usage:  "Run the userland proxy of a published port",
+		Hidden: true,
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() < 3 {
+				return errors.New("please input endpoint id, port mapping and container ip")
+			}
+			return ServePortProxy(ctx.Args().Get(0), ctx.Args().Get(1), ctx.Args().Get(2))
+		},
+	}
+}
@@ -1,6 +1,15 @@
 package networks
 
 var (
-	DefaultNetworkPath       string = "/var/run/miniker/network/network/"
-	DefaultIpamAllocatorPath string = "/var/run/miniker/network/ipam/subnet.json"
+	// 网络和ip分配信息需要在重启后保留，存放在持久化的目录中
+	DefaultNetworkPath       string = "/var/lib/miniker/network/network/"
+	DefaultIpamAllocatorPath string = "/var/lib/miniker/network/ipam/subnet.json"
+	DefaultEndpointPath      string = "/var/lib/miniker/network/endpoint/"
+	// dns服务的运行时信息
+	DefaultDNSPath string = "/var/run/miniker/network/dns/"
+	// 端口代理的日志
+	DefaultProxyPath string = "/var/run/miniker/network/proxy/"
+	// 旧版本存放网络和ip分配信息的目录，启动时迁移到持久化的目录
+	LegacyNetworkPath       string = "/var/run/miniker/network/network/"
+	LegacyIpamAllocatorPath string = "/var/run/miniker/network/ipam/subnet.json"
 )
@@ -0,0 +1,376 @@
+package networks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// dns应答中记录的有效时间，容器断开连接后记录很快失效
+const dnsTTL = 10
+
+// 转发查询到上游dns服务器的超时时间
+const dnsForwardTimeout = 2 * time.Second
+
+// 宿主机没有可用的dns服务器时使用的上游服务器
+var DefaultUpstreamNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+// dns记录，一个网络端点对应的ip和可解析的名称
+type dnsEntry struct {
+	IP    net.IP   `json:"ip"`
+	Names []string `json:"names"`
+}
+
+// 网络中所有的dns记录，key为网络端点的id
+type dnsRecords map[string]*dnsEntry
+
+// 网络dns记录的存储路径
+func dnsRecordsPath(networkName string) string {
+	return path.Join(DefaultDNSPath, networkName+".json")
+}
+
+// 网络dns服务进程的pid文件
+func dnsPidPath(networkName string) string {
+	return path.Join(DefaultDNSPath, networkName+".pid")
+}
+
+// 读取网络的dns记录，文件不存在时返回空记录
+func loadDNSRecords(networkName string) (dnsRecords, error) {
+	records := dnsRecords{}
+	content, err := os.ReadFile(dnsRecordsPath(networkName))
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// 在文件锁的保护下修改网络的dns记录，防止多个容器同时连接时互相覆盖
+func updateDNSRecords(networkName string, update func(records dnsRecords)) error {
+	if err := os.MkdirAll(DefaultDNSPath, 0755); err != nil {
+		return err
+	}
+	lockFile, err := os.OpenFile(path.Join(DefaultDNSPath, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	records, err := loadDNSRecords(networkName)
+	if err != nil {
+		return err
+	}
+	update(records)
+	content, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，dns服务不会读到写了一半的文件
+	tmpPath := dnsRecordsPath(networkName) + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dnsRecordsPath(networkName))
+}
+
+// 为网络端点添加dns记录
+func registerDNS(endpoint *EndPoint, names []string) error {
+	entry := &dnsEntry{IP: endpoint.IPAddress}
+	for _, name := range names {
+		if name != "" {
+			entry.Names = append(entry.Names, strings.ToLower(name))
+		}
+	}
+	return updateDNSRecords(endpoint.NetWork.Name, func(records dnsRecords) {
+		records[endpoint.Id] = entry
+	})
+}
+
+// 删除网络端点的dns记录
+func unregisterDNS(networkName, endpointId string) error {
+	return updateDNSRecords(networkName, func(records dnsRecords) {
+		delete(records, endpointId)
+	})
+}
+
+// 确保网络的dns服务正在运行，未运行时在后台启动
+func ensureDNSServer(nw *Network) error {
+	if pid, err := readDNSPid(nw.Name); err == nil && syscall.Kill(pid, 0) == nil {
+		return nil
+	}
+	if err := os.MkdirAll(DefaultDNSPath, 0755); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(path.Join(DefaultDNSPath, nw.Name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	// dns服务作为独立的会话运行，不随当前命令退出
+	cmd := exec.Command("/proc/self/exe", "network", "dns", nw.Name)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// 停止网络的dns服务，并删除其记录
+func stopDNSServer(networkName string) {
+	if pid, err := readDNSPid(networkName); err == nil {
+		syscall.Kill(pid, syscall.SIGTERM)
+	}
+	for _, file := range []string{dnsPidPath(networkName), dnsRecordsPath(networkName)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logger.Sugar().Errorf("remove %s err %v", file, err)
+		}
+	}
+}
+
+// 读取dns服务进程的pid
+func readDNSPid(networkName string) (int, error) {
+	content, err := os.ReadFile(dnsPidPath(networkName))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// 网络的dns服务，解析网络中的容器名，其他查询转发给上游服务器
+type dnsServer struct {
+	network   string
+	upstreams []string
+
+	mu      sync.Mutex
+	records dnsRecords
+	modTime time.Time
+}
+
+// 在网关ip上运行网络的dns服务
+func ServeDNS(networkName string) error {
+	nw, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("no such network %s", networkName)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: nw.IpRange.IP, Port: 53})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := os.WriteFile(dnsPidPath(networkName), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return err
+	}
+
+	server := &dnsServer{
+		network:   networkName,
+		upstreams: hostNameservers("/etc/resolv.conf"),
+	}
+	logger.Sugar().Infof("dns server of network %s listening on %s, upstreams %v", networkName, conn.LocalAddr(), server.upstreams)
+	buf := make([]byte, 65535)
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go server.handle(conn, client, query)
+	}
+}
+
+// 读取宿主机的dns服务器，dns服务运行在宿主机的网络空间，可以使用回环地址上的服务器
+func hostNameservers(resolvConf string) []string {
+	var nameservers []string
+	file, err := os.Open(resolvConf)
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 1 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+				nameservers = append(nameservers, fields[1])
+			}
+		}
+	}
+	if len(nameservers) == 0 {
+		nameservers = DefaultUpstreamNameservers
+	}
+	return nameservers
+}
+
+// 处理一个查询
+func (s *dnsServer) handle(conn *net.UDPConn, client *net.UDPAddr, query []byte) {
+	name, qtype, questionEnd, err := parseDNSQuestion(query)
+	if err != nil {
+		logger.Sugar().Debugf("invalid dns query from %s: %v", client, err)
+		return
+	}
+	if ip, ok := s.lookup(name); ok {
+		conn.WriteToUDP(buildDNSAnswer(query[:questionEnd], qtype, ip), client)
+		return
+	}
+	resp, err := s.forward(query)
+	if err != nil {
+		logger.Sugar().Errorf("forward dns query %s err %v", name, err)
+		conn.WriteToUDP(buildDNSError(query[:questionEnd], dnsRcodeServFail), client)
+		return
+	}
+	conn.WriteToUDP(resp, client)
+}
+
+// 查找名称对应的ip，记录文件变化后重新加载
+func (s *dnsServer) lookup(name string) (net.IP, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info, err := os.Stat(dnsRecordsPath(s.network)); err == nil && !info.ModTime().Equal(s.modTime) {
+		records, err := loadDNSRecords(s.network)
+		if err != nil {
+			logger.Sugar().Errorf("load dns records err %v", err)
+		} else {
+			s.records = records
+			s.modTime = info.ModTime()
+		}
+	}
+	for _, entry := range s.records {
+		for _, n := range entry.Names {
+			if n == name {
+				return entry.IP, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// 将查询转发给上游服务器，依次尝试直到成功
+func (s *dnsServer) forward(query []byte) ([]byte, error) {
+	err := errors.New("no upstream nameservers")
+	for _, upstream := range s.upstreams {
+		var resp []byte
+		if resp, err = forwardDNS(query, net.JoinHostPort(upstream, "53")); err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+func forwardDNS(query []byte, upstream string) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", upstream, dnsForwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsForwardTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+const (
+	dnsHeaderSize    = 12
+	dnsTypeA         = 1
+	dnsTypeAny       = 255
+	dnsClassIN       = 1
+	dnsRcodeServFail = 2
+)
+
+// 解析只包含一个问题的查询，返回小写的名称(不含末尾的'.')、查询类型和问题部分的结束位置
+func parseDNSQuestion(msg []byte) (string, uint16, int, error) {
+	if len(msg) < dnsHeaderSize {
+		return "", 0, 0, errors.New("message too short")
+	}
+	if msg[2]&0x80 != 0 {
+		return "", 0, 0, errors.New("not a query")
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return "", 0, 0, errors.New("expect exactly one question")
+	}
+
+	var labels []string
+	offset := dnsHeaderSize
+	for {
+		if offset >= len(msg) {
+			return "", 0, 0, errors.New("truncated question")
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		// 查询中的问题不使用压缩
+		if length&0xC0 != 0 || offset+length > len(msg) {
+			return "", 0, 0, errors.New("invalid label")
+		}
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+	if offset+4 > len(msg) {
+		return "", 0, 0, errors.New("truncated question")
+	}
+	qtype := binary.BigEndian.Uint16(msg[offset : offset+2])
+	return strings.ToLower(strings.Join(labels, ".")), qtype, offset + 4, nil
+}
+
+// 根据查询生成应答的头部和问题部分
+func dnsResponseHeader(question []byte, rcode byte, answers uint16) []byte {
+	resp := append([]byte(nil), question...)
+	// QR=1，保留opcode和RD，AA=1
+	resp[2] = 0x80 | question[2]&0x79 | 0x04
+	// RA=1
+	resp[3] = 0x80 | rcode
+	binary.BigEndian.PutUint16(resp[6:8], answers)
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+	return resp
+}
+
+// 生成网络中容器名的应答，只有A记录，其他类型返回空应答
+func buildDNSAnswer(question []byte, qtype uint16, ip net.IP) []byte {
+	ip4 := ip.To4()
+	if ip4 == nil || (qtype != dnsTypeA && qtype != dnsTypeAny) {
+		return dnsResponseHeader(question, 0, 0)
+	}
+	resp := dnsResponseHeader(question, 0, 1)
+	answer := make([]byte, 16)
+	// 名称使用指向问题的压缩指针
+	binary.BigEndian.PutUint16(answer[0:2], 0xC000|dnsHeaderSize)
+	binary.BigEndian.PutUint16(answer[2:4], dnsTypeA)
+	binary.BigEndian.PutUint16(answer[4:6], dnsClassIN)
+	binary.BigEndian.PutUint32(answer[6:10], dnsTTL)
+	binary.BigEndian.PutUint16(answer[10:12], net.IPv4len)
+	copy(answer[12:], ip4)
+	return append(resp, answer...)
+}
+
+// 生成错误应答
+func buildDNSError(question []byte, rcode byte) []byte {
+	return dnsResponseHeader(question, rcode, 0)
+}
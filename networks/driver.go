@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/vishvananda/netlink"
@@ -24,6 +24,8 @@ type NetworkDriver interface {
 	Connect(network *Network, endpoint *EndPoint) error
 	// 断开连接
 	Disconnect(network *Network, endpoint *EndPoint) error
+	// 检查网络在宿主机上的状态，恢复缺失的部分
+	Reconcile(network *Network) error
 }
 
 // 网络驱动的具体实现
@@ -73,9 +75,12 @@ func (b *BridgeNetworkDriver) initBridge(nw *Network) error {
 		return err
 	}
 	logger.Sugar().Info("set interface up")
-	// 设置iptables的SNAT规则
-	if err := setupIPTables(bridgeName, nw.IpRange); err != nil {
-		return err
+	// 设置iptables的SNAT规则，并记录在网络中，删除网络时一并删除
+	for _, rule := range bridgeNatRules(bridgeName, nw.IpRange) {
+		if err := addNatRule(rule); err != nil {
+			return err
+		}
+		nw.Rules = append(nw.Rules, rule)
 	}
 	logger.Sugar().Info("set interface iptables")
 	return nil
@@ -142,20 +147,40 @@ func setInterfaceUP(interfaceName string) error {
 	return nil
 }
 
-func setupIPTables(bridgeName string, subnet *net.IPNet) error {
-	// 设置iptables的MASQUERADE规则
-	// iptables -t nat -A POSTROUTING -s <bridgeName> ! -o <bridgeName> -j MASQUERADE
-	iptablesCmd := fmt.Sprintf("-t nat -A POSTROUTING -s %s ! -o %s -j MASQUERADE", subnet.String(), bridgeName)
-	cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-	output, err := cmd.Output()
-	if err != nil {
-		logger.Sugar().Errorf("iptables Output, %v", output)
+// 网络的MASQUERADE规则，容器访问外部网络时将源地址转换为宿主机的地址
+// iptables -t nat -A MINIKER-POSTROUTING -s <subnet> ! -o <bridgeName> -j MASQUERADE
+func masqueradeRule(bridgeName string, subnet *net.IPNet) NatRule {
+	return NatRule{
+		Chain:       ChainPostrouting,
+		Source:      subnet.String(),
+		NotOutIface: bridgeName,
+		Target:      "MASQUERADE",
+	}
+}
+
+// 网络的hairpin规则。容器通过宿主机的地址访问同一网络中容器的映射端口时，
+// 将源地址转换为网关地址，保证回包经过宿主机还原DNAT
+// iptables -t nat -A MINIKER-POSTROUTING -s <subnet> -o <bridgeName> -m conntrack --ctstate DNAT -j MASQUERADE
+func hairpinRule(bridgeName string, subnet *net.IPNet) NatRule {
+	return NatRule{
+		Chain:    ChainPostrouting,
+		Source:   subnet.String(),
+		OutIface: bridgeName,
+		CtState:  "DNAT",
+		Target:   "MASQUERADE",
 	}
-	return err
 }
 
-// 删除网络
+// bridge网络需要的所有nat规则
+func bridgeNatRules(bridgeName string, subnet *net.IPNet) []NatRule {
+	return []NatRule{masqueradeRule(bridgeName, subnet), hairpinRule(bridgeName, subnet)}
+}
+
+// 删除网络，同时删除网络安装的iptables规则
 func (b *BridgeNetworkDriver) Delete(network *Network) error {
+	if err := deleteNatRules(network.Rules); err != nil {
+		return err
+	}
 	br, err := netlink.LinkByName(network.Name)
 	if err != nil {
 		return err
@@ -174,22 +199,27 @@ func (b *BridgeNetworkDriver) Connect(network *Network, endpoint *EndPoint) erro
 	// 对veth进行配置
 	la := netlink.NewLinkAttrs()
 	// veth的名字
-	la.Name = endpoint.Id[:5]
+	la.Name = vethName(endpoint.Id)
 	// 将veth的一端连接到bridge
 	la.MasterIndex = br.Attrs().Index
 	// 创建veth
 	endpoint.Device = netlink.Veth{
 		LinkAttrs: la,
-		PeerName:  "cif-" + endpoint.Id[:5],
+		PeerName:  peerName(la.Name),
 	}
 	if err := netlink.LinkAdd(&endpoint.Device); err != nil {
 		return fmt.Errorf("error add endpoint device: %v", err)
 	}
+	endpoint.Veth = la.Name
 
 	// 将veth设置为UP
 	if err := netlink.LinkSetUp(&endpoint.Device); err != nil {
 		return fmt.Errorf("error set endpoint device up: %v", err)
 	}
+	// 开启hairpin模式，容器经过bridge访问自己的映射端口时，报文可以从同一端口发回
+	if err := netlink.LinkSetHairpin(&endpoint.Device, true); err != nil {
+		return fmt.Errorf("error set endpoint device hairpin: %v", err)
+	}
 	return nil
 }
 
@@ -203,7 +233,11 @@ func configEndpointIpAndRoute(endpoint *EndPoint, pid int) error {
 
 	// 将veth的另一端加入到容器的网络空间中
 	// 当前函数执行完，需要从容器的网络空间中回到之前的网络空间
-	defer enterContainerNetns(&peerLink, pid)()
+	exit, err := enterContainerNetns(&peerLink, pid)
+	if err != nil {
+		return err
+	}
+	defer exit()
 
 	// 获取容器网络的ip地址和网段
 	interfaceIP := *endpoint.NetWork.IpRange
@@ -216,88 +250,154 @@ func configEndpointIpAndRoute(endpoint *EndPoint, pid int) error {
 	if err := setInterfaceUP(endpoint.Device.PeerName); err != nil {
 		return err
 	}
+	// 记录容器中veth的mac地址
+	if link, err := netlink.LinkByName(endpoint.Device.PeerName); err == nil {
+		endpoint.MacAddress = link.Attrs().HardwareAddr.String()
+	}
 	// 开启"lo"网络接口
 	if err := setInterfaceUP("lo"); err != nil {
 		return err
 	}
-	// 设置容器的路由
+	// 设置容器的路由，容器连接多个网络时默认路由使用最先连接的网络
+	if hasDefaultRoute() {
+		return nil
+	}
+	return addDefaultRoute(peerLink, endpoint.NetWork.IpRange.IP)
+}
+
+// 检查当前network namespace中是否有默认路由
+func hasDefaultRoute() bool {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return false
+	}
+	for _, route := range routes {
+		if route.Dst == nil || route.Dst.String() == "0.0.0.0/0" {
+			return true
+		}
+	}
+	return false
+}
+
+// 在当前network namespace中添加经由网关的默认路由
+func addDefaultRoute(link netlink.Link, gateway net.IP) error {
 	// 0.0.0.0/0 表示所有的ip地址
 	_, cidr, _ := net.ParseCIDR("0.0.0.0/0")
 	defaultRoute := &netlink.Route{
-		LinkIndex: peerLink.Attrs().Index,
-		Gw:        endpoint.NetWork.IpRange.IP,
+		LinkIndex: link.Attrs().Index,
+		Gw:        gateway,
 		Dst:       cidr,
 	}
 	if err := netlink.RouteAdd(defaultRoute); err != nil {
 		logger.Sugar().Errorf("error set route, %v", err)
 		return err
 	}
-
 	return nil
 }
 
-func enterContainerNetns(nwLink *netlink.Link, pid int) func() {
-	// 访问 /proc/[pid]/ns/net 文件
-	f, err := os.Open("/proc/" + fmt.Sprintf("%d", pid) + "/ns/net")
+// 进入容器的network namespace，返回退回原network namespace的函数
+func enterNetns(pid int) (func(), error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
 	if err != nil {
-		logger.Sugar().Errorf("error get container net namespace, %v", err)
+		return nil, err
 	}
-
-	// 获取文件描述符
-	nsFD := f.Fd()
-
 	// 锁定程序现场，否则无法保证一直处于正确的网络空间
 	runtime.LockOSThread()
-
-	// 将网络接口挂在到容器的net namespace
-	if err := netlink.LinkSetNsFd(*nwLink, int(nsFD)); err != nil {
-		logger.Sugar().Errorf("error set link netns: %v", err)
-	}
-
-	// 获取当前网络的namespace，便于后续退回
-	oringinNs, err := netns.Get()
+	originNs, err := netns.Get()
 	if err != nil {
-		logger.Sugar().Errorf("error get current netns, %v", err)
+		runtime.UnlockOSThread()
+		f.Close()
+		return nil, err
 	}
-
-	// 将当前进程加入容器的net namespace
-	if err := netns.Set(netns.NsHandle(nsFD)); err != nil {
-		logger.Sugar().Errorf("error set netns, %v", err)
+	if err := netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		originNs.Close()
+		runtime.UnlockOSThread()
+		f.Close()
+		return nil, err
 	}
-
 	return func() {
-		// 退回之前的net namespace
-		netns.Set(oringinNs)
-		// 关闭namespace文件
-		oringinNs.Close()
-		// 取消对线程的锁定
+		netns.Set(originNs)
+		originNs.Close()
 		runtime.UnlockOSThread()
-		// 关闭namespace文件
 		f.Close()
+	}, nil
+}
+
+// 将网络接口移动到容器的network namespace，并进入该namespace，返回退回原namespace的函数
+func enterContainerNetns(nwLink *netlink.Link, pid int) (func(), error) {
+	if err := netlink.LinkSetNsPid(*nwLink, pid); err != nil {
+		return nil, fmt.Errorf("error set link netns: %v", err)
 	}
+	return enterNetns(pid)
 }
 
-// 端口映射
+// 端口映射，安装的规则记录在网络端点中。安装失败时返回错误，已安装的规则在释放网络端点时删除
 func configPortMapping(endpoint *EndPoint) error {
-	for _, pm := range endpoint.PortMapping {
-		ports := strings.Split(pm, ":")
-		if len(ports) != 2 {
-			logger.Sugar().Errorf("port mapping format error, %v", pm)
-			continue
+	rules, err := portMappingRules(endpoint, ChainDNAT)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := addNatRule(rule); err != nil {
+			return err
+		}
+		endpoint.Rules = append(endpoint.Rules, rule)
+	}
+	return nil
+}
+
+// 删除网络端点安装的端口映射规则
+func removePortMapping(endpoint *EndPoint) error {
+	rules := endpoint.Rules
+	if rules == nil {
+		// 旧版本没有记录规则，端口映射直接安装在PREROUTING链中
+		var err error
+		if rules, err = portMappingRules(endpoint, "PREROUTING"); err != nil {
+			logger.Sugar().Errorf("parse port mapping of endpoint %s err %v", endpoint.Id, err)
 		}
+	}
+	return deleteNatRules(rules)
+}
 
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s", ports[0], endpoint.IPAddress.String(), ports[1])
-		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-		output, err := cmd.Output()
+// 端口映射的DNAT规则，端口范围中的每个端口使用一条规则
+// iptables -t nat -A MINIKER-DNAT [-d <hostIP>/32] -p <protocol> -m <protocol> --dport <hostPort> -j DNAT --to-destination <ip>:<containerPort>
+func portMappingRules(endpoint *EndPoint, chain string) ([]NatRule, error) {
+	var rules []NatRule
+	for _, spec := range endpoint.PortMapping {
+		pm, err := ParsePortMapping(spec)
 		if err != nil {
-			logger.Sugar().Errorf("iptables err %v, output %v", err, output)
-			continue
+			return nil, err
+		}
+		destination := ""
+		if pm.HostIP != nil {
+			destination = pm.HostIP.String() + "/32"
+		}
+		for offset := 0; pm.HostPort+offset <= pm.HostPortEnd; offset++ {
+			rules = append(rules, NatRule{
+				Chain:         chain,
+				Destination:   destination,
+				Protocol:      pm.Protocol,
+				DstPort:       strconv.Itoa(pm.HostPort + offset),
+				Target:        "DNAT",
+				ToDestination: fmt.Sprintf("%s:%d", endpoint.IPAddress, pm.ContainerPort+offset),
+			})
 		}
 	}
-	return nil
+	return rules, nil
 }
 
-// 断开连接
+// 断开连接，删除网络端点的veth。veth的另一端位于容器中，会被一起删除
 func (b *BridgeNetworkDriver) Disconnect(network *Network, endpoint *EndPoint) error {
-	return nil
+	if endpoint.Veth == "" {
+		return nil
+	}
+	link, err := netlink.LinkByName(endpoint.Veth)
+	if err != nil {
+		// 容器退出后其network namespace被销毁，veth随之删除
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	return netlink.LinkDel(link)
 }
@@ -0,0 +1,190 @@
+package networks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// 网络端点的id，由容器名和网络名组成
+func endpointId(containerName, networkName string) string {
+	return fmt.Sprintf("%s-%s", containerName, networkName)
+}
+
+// 网络端点的存储路径，每个网络的端点位于单独的目录
+func endpointPath(networkName, id string) string {
+	return path.Join(DefaultEndpointPath, networkName, id+".json")
+}
+
+// 网络端点对应的veth名称。网络接口名最长15个字符，使用端点id的摘要避免重名
+func vethName(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "veth" + hex.EncodeToString(sum[:])[:8]
+}
+
+// veth在容器中一端的名称
+func peerName(veth string) string {
+	return "cif-" + strings.TrimPrefix(veth, "veth")
+}
+
+// 将网络端点存储到文件
+func (ep *EndPoint) dump() error {
+	epPath := endpointPath(ep.NetWork.Name, ep.Id)
+	if err := os.MkdirAll(path.Dir(epPath), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(ep)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(epPath, content, 0644)
+}
+
+// 删除网络端点的文件
+func (ep *EndPoint) remove() error {
+	err := os.Remove(endpointPath(ep.NetWork.Name, ep.Id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 从文件中加载网络端点
+func loadEndpoint(nw *Network, id string) (*EndPoint, error) {
+	content, err := os.ReadFile(endpointPath(nw.Name, id))
+	if err != nil {
+		return nil, err
+	}
+	ep := &EndPoint{}
+	if err := json.Unmarshal(content, ep); err != nil {
+		return nil, err
+	}
+	ep.NetWork = nw
+	return ep, nil
+}
+
+// 列出网络中所有的网络端点
+func listEndpoints(nw *Network) ([]*EndPoint, error) {
+	entries, err := os.ReadDir(path.Join(DefaultEndpointPath, nw.Name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []*EndPoint
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		ep, err := loadEndpoint(nw, strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			logger.Sugar().Errorf("load endpoint %s err %v", entry.Name(), err)
+			continue
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, nil
+}
+
+// 释放网络端点占用的所有资源：veth、端口映射和代理、ip地址和dns记录。
+// 某一步失败时继续释放其余的资源，返回遇到的第一个错误
+func teardownEndpoint(nw *Network, ep *EndPoint) error {
+	var firstErr error
+	record := func(err error) {
+		if err != nil {
+			logger.Sugar().Errorf("teardown endpoint %s err %v", ep.Id, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	record(drivers[nw.Driver].Disconnect(nw, ep))
+	record(removePortMapping(ep))
+	record(stopPortProxies(ep))
+	if ep.IPAddress != nil {
+		record(ipAllocator.Release(nw.IpRange, ep.IPAddress))
+	}
+	record(unregisterDNS(nw.Name, ep.Id))
+	record(ep.remove())
+	return firstErr
+}
+
+// 断开容器与网络的连接。pid为运行中容器的进程号，
+// 断开后容器仍连接着其他网络时，保证容器有可用的默认路由
+func Disconnect(networkName, containerName string, pid int) error {
+	nw, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("no such network %s", networkName)
+	}
+	ep, err := loadEndpoint(nw, endpointId(containerName, networkName))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("container %s is not connected to network %s", containerName, networkName)
+	}
+	if err != nil {
+		return err
+	}
+	if err := teardownEndpoint(nw, ep); err != nil {
+		return err
+	}
+	if pid <= 0 {
+		return nil
+	}
+	if remaining := ContainerEndpoints(containerName); len(remaining) > 0 {
+		return restoreDefaultRoute(remaining[0], pid)
+	}
+	return nil
+}
+
+// 容器的默认路由随断开的veth删除后，经由另一个网络重新添加
+func restoreDefaultRoute(ep *EndPoint, pid int) error {
+	exit, err := enterNetns(pid)
+	if err != nil {
+		return err
+	}
+	defer exit()
+	if hasDefaultRoute() {
+		return nil
+	}
+	link, err := netlink.LinkByName(peerName(ep.Veth))
+	if err != nil {
+		return err
+	}
+	return addDefaultRoute(link, ep.NetWork.IpRange.IP)
+}
+
+// 获取容器在所有网络中的网络端点
+func ContainerEndpoints(containerName string) []*EndPoint {
+	var endpoints []*EndPoint
+	for networkName, nw := range networks {
+		ep, err := loadEndpoint(nw, endpointId(containerName, networkName))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			logger.Sugar().Errorf("load endpoint of container %s in network %s err %v", containerName, networkName, err)
+			continue
+		}
+		endpoints = append(endpoints, ep)
+	}
+	// 按网络名排序，保证结果稳定
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].NetWork.Name < endpoints[j].NetWork.Name
+	})
+	return endpoints
+}
+
+// 断开容器与所有网络的连接，用于容器停止或删除时
+func DisconnectAll(containerName string) {
+	for _, ep := range ContainerEndpoints(containerName) {
+		teardownEndpoint(ep.NetWork, ep)
+	}
+}
@@ -0,0 +1,173 @@
+package networks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// miniker在nat表中使用的链，所有规则都安装在这些链中，便于查找和清理
+const (
+	ChainPostrouting = "MINIKER-POSTROUTING"
+	ChainDNAT        = "MINIKER-DNAT"
+)
+
+// 内置链到miniker链的跳转规则。宿主机上访问本机地址的连接经过OUTPUT链，
+// 访问127.0.0.0/8的连接不能转发到容器，由端口代理处理
+var chainJumps = []NatRule{
+	{Chain: "POSTROUTING", Target: ChainPostrouting},
+	{Chain: "PREROUTING", DstType: "LOCAL", Target: ChainDNAT},
+	{Chain: "OUTPUT", NotDestination: "127.0.0.0/8", DstType: "LOCAL", Target: ChainDNAT},
+}
+
+// miniker安装的一条nat规则，网络和网络端点记录各自安装的规则，删除时按记录清理。
+// 规则与具体的防火墙后端无关，由后端翻译成iptables参数或者nftables表达式
+type NatRule struct {
+	Chain string `json:"chain"`
+	// 匹配条件
+	Source         string `json:"source,omitempty"`
+	Destination    string `json:"destination,omitempty"`
+	NotDestination string `json:"notDestination,omitempty"`
+	OutIface       string `json:"outIface,omitempty"`
+	NotOutIface    string `json:"notOutIface,omitempty"`
+	DstType        string `json:"dstType,omitempty"`
+	Protocol       string `json:"protocol,omitempty"`
+	DstPort        string `json:"dstPort,omitempty"`
+	// 连接跟踪状态，如DNAT
+	CtState string `json:"ctState,omitempty"`
+	// 动作，MASQUERADE、DNAT或者跳转的链
+	Target        string `json:"target"`
+	ToDestination string `json:"toDestination,omitempty"`
+}
+
+// 规则的文本形式，与iptables -S的输出一致
+func (r NatRule) String() string {
+	return strings.Join(r.iptablesArgs("-A")[2:], " ")
+}
+
+// 防火墙后端接口，负责在宿主机的nat表中安装和删除规则
+type Firewall interface {
+	// 后端名
+	Name() string
+	// 创建链，链已存在时忽略
+	NewChain(chain string) error
+	// 检查规则是否存在
+	HasRule(rule NatRule) bool
+	// 安装规则
+	AddRule(rule NatRule) error
+	// 删除规则
+	DeleteRule(rule NatRule) error
+}
+
+// 当前使用的防火墙后端，为nil时在第一次使用前自动检测
+var firewall Firewall
+
+var firewallOnce sync.Once
+var firewallErr error
+
+// 获取防火墙后端。宿主机有iptables命令时优先使用iptables，否则通过netlink使用nftables
+func getFirewall() (Firewall, error) {
+	firewallOnce.Do(func() {
+		if firewall != nil {
+			return
+		}
+		var errs []string
+		for _, detect := range []func() (Firewall, error){newIptablesFirewall, newNftablesFirewall} {
+			fw, err := detect()
+			if err == nil {
+				logger.Sugar().Infof("use %s firewall", fw.Name())
+				firewall = fw
+				return
+			}
+			errs = append(errs, err.Error())
+		}
+		firewallErr = fmt.Errorf("no available firewall: %s", strings.Join(errs, "; "))
+	})
+	if firewall == nil {
+		if firewallErr == nil {
+			firewallErr = errors.New("no available firewall")
+		}
+		return nil, firewallErr
+	}
+	return firewall, nil
+}
+
+var chainsOnce sync.Once
+var chainsErr error
+
+// 替换防火墙后端，并在下次使用时重新检查miniker的链。用于测试中注入fake后端
+func useFirewall(fw Firewall) {
+	firewall = fw
+	firewallOnce = sync.Once{}
+	firewallErr = nil
+	chainsOnce = sync.Once{}
+	chainsErr = nil
+}
+
+// 创建miniker的链并从内置链跳转过去，每个进程只需检查一次
+func ensureChains() error {
+	fw, err := getFirewall()
+	if err != nil {
+		return err
+	}
+	chainsOnce.Do(func() {
+		for _, chain := range []string{ChainPostrouting, ChainDNAT} {
+			if chainsErr = fw.NewChain(chain); chainsErr != nil {
+				return
+			}
+		}
+		for _, jump := range chainJumps {
+			if fw.HasRule(jump) {
+				continue
+			}
+			if chainsErr = fw.AddRule(jump); chainsErr != nil {
+				return
+			}
+		}
+	})
+	return chainsErr
+}
+
+// 检查规则是否存在
+func natRuleExists(rule NatRule) bool {
+	fw, err := getFirewall()
+	if err != nil {
+		return false
+	}
+	return fw.HasRule(rule)
+}
+
+// 安装规则，规则已存在时不重复添加
+func addNatRule(rule NatRule) error {
+	if err := ensureChains(); err != nil {
+		return err
+	}
+	if firewall.HasRule(rule) {
+		return nil
+	}
+	return firewall.AddRule(rule)
+}
+
+// 删除规则，规则不存在时忽略
+func deleteNatRule(rule NatRule) error {
+	fw, err := getFirewall()
+	if err != nil {
+		return err
+	}
+	if !fw.HasRule(rule) {
+		return nil
+	}
+	return fw.DeleteRule(rule)
+}
+
+// 删除一组规则，返回遇到的第一个错误
+func deleteNatRules(rules []NatRule) error {
+	var firstErr error
+	for _, rule := range rules {
+		if err := deleteNatRule(rule); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,220 @@
+package networks
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables/expr"
+)
+
+// 在内存中记录链和规则的防火墙后端
+type fakeFirewall struct {
+	chains map[string]bool
+	rules  map[string][]NatRule
+	// 各操作的调用次数
+	adds    int
+	deletes int
+}
+
+func newFakeFirewall() *fakeFirewall {
+	return &fakeFirewall{chains: map[string]bool{}, rules: map[string][]NatRule{}}
+}
+
+func (f *fakeFirewall) Name() string {
+	return "fake"
+}
+
+func (f *fakeFirewall) NewChain(chain string) error {
+	f.chains[chain] = true
+	return nil
+}
+
+func (f *fakeFirewall) HasRule(rule NatRule) bool {
+	for _, r := range f.rules[rule.Chain] {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeFirewall) AddRule(rule NatRule) error {
+	f.adds++
+	f.rules[rule.Chain] = append(f.rules[rule.Chain], rule)
+	return nil
+}
+
+func (f *fakeFirewall) DeleteRule(rule NatRule) error {
+	f.deletes++
+	rules := f.rules[rule.Chain]
+	for i, r := range rules {
+		if r == rule {
+			f.rules[rule.Chain] = append(rules[:i], rules[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func withFakeFirewall(t *testing.T) *fakeFirewall {
+	fw := newFakeFirewall()
+	useFirewall(fw)
+	t.Cleanup(func() { useFirewall(nil) })
+	return fw
+}
+
+func TestEnsureChains(t *testing.T) {
+	fw := withFakeFirewall(t)
+	for i := 0; i < 2; i++ {
+		if err := ensureChains(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, chain := range []string{ChainPostrouting, ChainDNAT} {
+		if !fw.chains[chain] {
+			t.Errorf("chain %s not created", chain)
+		}
+	}
+	if fw.adds != len(chainJumps) {
+		t.Errorf("added %d jump rules, want %d", fw.adds, len(chainJumps))
+	}
+
+	// 重新检查时不重复添加已存在的跳转规则
+	useFirewall(fw)
+	if err := ensureChains(); err != nil {
+		t.Fatal(err)
+	}
+	if fw.adds != len(chainJumps) {
+		t.Errorf("added %d jump rules after recheck, want %d", fw.adds, len(chainJumps))
+	}
+}
+
+func TestAddDeleteNatRule(t *testing.T) {
+	fw := withFakeFirewall(t)
+	rule := NatRule{Chain: ChainDNAT, Protocol: "tcp", DstPort: "8080", Target: "DNAT", ToDestination: "10.0.0.2:80"}
+
+	for i := 0; i < 2; i++ {
+		if err := addNatRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(fw.rules[ChainDNAT]); got != 1 {
+		t.Fatalf("%d rules in %s, want 1", got, ChainDNAT)
+	}
+	if !natRuleExists(rule) {
+		t.Fatal("rule does not exist after add")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := deleteNatRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if natRuleExists(rule) {
+		t.Fatal("rule exists after delete")
+	}
+	if fw.deletes != 1 {
+		t.Errorf("deleted %d times, want 1", fw.deletes)
+	}
+}
+
+func TestIptablesArgs(t *testing.T) {
+	tests := []struct {
+		rule NatRule
+		want string
+	}{
+		{
+			rule: NatRule{Chain: ChainPostrouting, Source: "10.0.0.0/24", NotOutIface: "br0", Target: "MASQUERADE"},
+			want: "-t nat -A MINIKER-POSTROUTING -s 10.0.0.0/24 ! -o br0 -j MASQUERADE",
+		},
+		{
+			rule: NatRule{Chain: ChainPostrouting, Source: "10.0.0.0/24", OutIface: "br0", CtState: "DNAT", Target: "MASQUERADE"},
+			want: "-t nat -A MINIKER-POSTROUTING -s 10.0.0.0/24 -o br0 -m conntrack --ctstate DNAT -j MASQUERADE",
+		},
+		{
+			rule: NatRule{Chain: "OUTPUT", NotDestination: "127.0.0.0/8", DstType: "LOCAL", Target: ChainDNAT},
+			want: "-t nat -A OUTPUT ! -d 127.0.0.0/8 -m addrtype --dst-type LOCAL -j MINIKER-DNAT",
+		},
+		{
+			rule: NatRule{Chain: ChainDNAT, Destination: "127.0.0.1/32", Protocol: "udp", DstPort: "53", Target: "DNAT", ToDestination: "10.0.0.2:5353"},
+			want: "-t nat -A MINIKER-DNAT -d 127.0.0.1/32 -p udp -m udp --dport 53 -j DNAT --to-destination 10.0.0.2:5353",
+		},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(tt.rule.iptablesArgs("-A"), " "); got != tt.want {
+			t.Errorf("iptablesArgs() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestNftablesExprsMasquerade(t *testing.T) {
+	rule := NatRule{Chain: ChainPostrouting, Source: "10.0.0.1/24", NotOutIface: "br0", Target: "MASQUERADE"}
+	exprs, err := rule.nftablesExprs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{255, 255, 255, 0}, Xor: []byte{0, 0, 0, 0}},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{10, 0, 0, 0}},
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: ifname("br0")},
+		&expr.Masq{},
+	}
+	if !reflect.DeepEqual(exprs, want) {
+		t.Errorf("nftablesExprs() = %#v, want %#v", exprs, want)
+	}
+}
+
+func TestNftablesExprsDNAT(t *testing.T) {
+	rule := NatRule{Chain: ChainDNAT, Protocol: "tcp", DstPort: "8080", Target: "DNAT", ToDestination: "10.0.0.2:80"}
+	exprs, err := rule.nftablesExprs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{6}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x1f, 0x90}},
+		&expr.Immediate{Register: 1, Data: []byte{10, 0, 0, 2}},
+		&expr.Immediate{Register: 2, Data: []byte{0, 80}},
+		&expr.NAT{Type: expr.NATTypeDestNAT, Family: 2, RegAddrMin: 1, RegProtoMin: 2},
+	}
+	if !reflect.DeepEqual(exprs, want) {
+		t.Errorf("nftablesExprs() = %#v, want %#v", exprs, want)
+	}
+}
+
+// DNAT的端口范围不保持偏移，端口范围需要展开为每个端口一条规则
+func TestNftablesExprsRejectsPortRange(t *testing.T) {
+	rules := []NatRule{
+		{Chain: ChainDNAT, Protocol: "tcp", DstPort: "8000:8010", Target: "DNAT", ToDestination: "10.0.0.2:9000"},
+		{Chain: ChainDNAT, Protocol: "tcp", DstPort: "8000", Target: "DNAT", ToDestination: "10.0.0.2:9000-9010"},
+	}
+	for _, rule := range rules {
+		if _, err := rule.nftablesExprs(); err == nil {
+			t.Errorf("nftablesExprs() of %s should fail", rule)
+		}
+	}
+}
+
+func TestPortMappingRules(t *testing.T) {
+	endpoint := &EndPoint{IPAddress: net.ParseIP("10.0.0.2"), PortMapping: []string{"8080:80/tcp", "127.0.0.1:8000-8002:9000-9002/udp"}}
+	rules, err := portMappingRules(endpoint, ChainDNAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 端口范围中的每个端口按顺序映射到容器端口
+	want := []NatRule{
+		{Chain: ChainDNAT, Protocol: "tcp", DstPort: "8080", Target: "DNAT", ToDestination: "10.0.0.2:80"},
+		{Chain: ChainDNAT, Destination: "127.0.0.1/32", Protocol: "udp", DstPort: "8000", Target: "DNAT", ToDestination: "10.0.0.2:9000"},
+		{Chain: ChainDNAT, Destination: "127.0.0.1/32", Protocol: "udp", DstPort: "8001", Target: "DNAT", ToDestination: "10.0.0.2:9001"},
+		{Chain: ChainDNAT, Destination: "127.0.0.1/32", Protocol: "udp", DstPort: "8002", Target: "DNAT", ToDestination: "10.0.0.2:9002"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("portMappingRules() = %+v, want %+v", rules, want)
+	}
+}
@@ -22,6 +22,9 @@ func Init() error {
 	bridgeDriver := &BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = bridgeDriver
 
+	// 迁移旧版本存放在/var/run下的网络信息
+	migrateLegacyState()
+
 	// 检查网络的存储目录是否存在，如果不存在就创建
 	if _, err := os.Stat(DefaultNetworkPath); err != nil {
 		if os.IsNotExist(err) {
@@ -0,0 +1,47 @@
+package networks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// network inspect输出的网络信息
+type networkInspect struct {
+	Name      string      `json:"name"`
+	Driver    string      `json:"driver"`
+	Subnet    string      `json:"subnet"`
+	Gateway   string      `json:"gateway"`
+	Endpoints []*EndPoint `json:"endpoints"`
+}
+
+// 以json格式打印网络的配置和所有连接到网络的容器
+func inspectNetwork(networkName string) error {
+	nw, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("no such network %s", networkName)
+	}
+	endpoints, err := listEndpoints(nw)
+	if err != nil {
+		return err
+	}
+	if endpoints == nil {
+		endpoints = []*EndPoint{}
+	}
+
+	subnet := *nw.IpRange
+	subnet.IP = subnet.IP.Mask(subnet.Mask)
+	info := &networkInspect{
+		Name:      nw.Name,
+		Driver:    nw.Driver,
+		Subnet:    subnet.String(),
+		Gateway:   nw.IpRange.IP.String(),
+		Endpoints: endpoints,
+	}
+	content, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
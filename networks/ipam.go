@@ -2,6 +2,7 @@ package networks
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"syscall"
 )
 
 type IPAM struct {
@@ -25,8 +27,8 @@ var ipAllocator = &IPAM{
 func (ipam *IPAM) load() error {
 	// 检查文件是否存在
 	if _, err := os.Stat(ipam.SubnetAllocatorPath); err != nil {
+		// 还没有分配过ip
 		if os.IsNotExist(err) {
-			logger.Sugar().Errorf("no such file: %s", ipam.SubnetAllocatorPath)
 			return nil
 		}
 		return err
@@ -61,124 +63,111 @@ func (ipam *IPAM) load() error {
 
 // 将ip地址的分配信息存储到文件
 func (ipam *IPAM) dump() error {
-	allocateDir, _ := path.Split(ipam.SubnetAllocatorPath)
-	if _, err := os.Stat(allocateDir); err != nil {
-		// 如果目录不存在，则创建
-		if !os.IsNotExist(err) {
-			return err
-		}
-		if err := os.MkdirAll(allocateDir, 0622); err != nil {
-			logger.Sugar().Error(err)
-			return err
-		}
-	}
-
-	allocateFile, err := os.OpenFile(ipam.SubnetAllocatorPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	b, err := json.Marshal(ipam.Subnets)
 	if err != nil {
 		logger.Sugar().Error(err)
 		return err
 	}
-	defer allocateFile.Close()
 
-	b, err := json.Marshal(ipam.Subnets)
-	if err != nil {
+	// 先写临时文件再重命名，其他进程不会读到写了一半的文件
+	tmpPath := ipam.SubnetAllocatorPath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
 		logger.Sugar().Error(err)
 		return err
 	}
+	return os.Rename(tmpPath, ipam.SubnetAllocatorPath)
+}
 
-	_, err = allocateFile.Write(b)
+// 在文件锁的保护下重新加载、修改并保存ip的分配信息，防止多个命令同时分配或释放ip时互相覆盖
+func (ipam *IPAM) update(modify func() error) error {
+	allocateDir, _ := path.Split(ipam.SubnetAllocatorPath)
+	if err := os.MkdirAll(allocateDir, 0755); err != nil {
+		return err
+	}
+	lockFile, err := os.OpenFile(ipam.SubnetAllocatorPath+".lock", os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
-		logger.Sugar().Error(err)
 		return err
 	}
-	return nil
-}
-
-// 分配IP
-func (ipam *IPAM) Allocate(subnet *net.IPNet) (net.IP, error) {
-	if ipam.Subnets == nil {
-		ipam.Subnets = map[string]string{}
-		if err := ipam.load(); err != nil {
-			ipam.Subnets = nil
-			return nil, err
-		}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return err
 	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 
-	// ones是网络号位数，bits是ip的总位数
-	ones, bits := subnet.Mask.Size()
-	if _, exist := ipam.Subnets[subnet.String()]; !exist {
-		ipam.Subnets[subnet.String()] = strings.Repeat("0", 1<<(bits-ones))
+	ipam.Subnets = map[string]string{}
+	if err := ipam.load(); err != nil {
+		return err
 	}
+	if err := modify(); err != nil {
+		return err
+	}
+	return ipam.dump()
+}
 
-	// 分配ip
+// 分配IP，网段中的第一个地址和广播地址不会被分配
+func (ipam *IPAM) Allocate(subnet *net.IPNet) (net.IP, error) {
+	// 网络中保存的网段带有网关ip，统一使用网段的起始地址作为key
+	_, cidr, _ := net.ParseCIDR(subnet.String())
 	var ip net.IP
-	for i := range ipam.Subnets[subnet.String()] {
-		// 寻找第一个非1位
-		if ipam.Subnets[subnet.String()][i] == '0' {
-			ipalloc := []byte(ipam.Subnets[subnet.String()])
-			ipalloc[i] = '1'
-			ipam.Subnets[subnet.String()] = string(ipalloc)
-
-			cnt := i + 1
-			// 复制ip值，防止修改原值
-			ip = append([]byte(nil), subnet.IP.To4()...)
-			var max byte = 255
-			for j := 1; j <= 4; j++ {
-				rem := int(max - []byte(ip)[4-j])
-				if cnt > rem {
-					cnt -= rem
-					[]byte(ip)[4-j] = max
-				} else {
-					[]byte(ip)[4-j] += byte(cnt)
-					cnt = 0
-				}
-			}
-			break
+	err := ipam.update(func() error {
+		// ones是网络号位数，bits是ip的总位数
+		ones, bits := cidr.Mask.Size()
+		if _, exist := ipam.Subnets[cidr.String()]; !exist {
+			ipam.Subnets[cidr.String()] = strings.Repeat("0", 1<<(bits-ones))
 		}
-	}
 
-	logger.Sugar().Info("allocate ip ", ip)
-	// 存储ip的分配信息
-	if err := ipam.dump(); err != nil {
+		// 分配ip，第i位对应的ip是起始地址加上i+1
+		ipalloc := []byte(ipam.Subnets[cidr.String()])
+		for i := 0; i < len(ipalloc)-2; i++ {
+			// 寻找第一个非1位
+			if ipalloc[i] == '0' {
+				ipalloc[i] = '1'
+				ipam.Subnets[cidr.String()] = string(ipalloc)
+				ip = uint32ToIP(ipToUint32(cidr.IP) + uint32(i+1))
+				return nil
+			}
+		}
+		return fmt.Errorf("no available ip in subnet %s", cidr)
+	})
+	if err != nil {
 		return nil, err
 	}
+	logger.Sugar().Info("allocate ip ", ip)
 	return ip, nil
 }
 
 // 释放IP
 func (ipam *IPAM) Release(subnet *net.IPNet, ip net.IP) error {
-	// 加载ip的分配信息
-	if ipam.Subnets == nil {
-		ipam.Subnets = map[string]string{}
-		if err := ipam.load(); err != nil {
-			ipam.Subnets = nil
-			return err
-		}
-	}
-
 	// 获取subnet的网络分段信息，用于查询ip分配
 	_, cidr, _ := net.ParseCIDR(subnet.String())
-	if ipam.Subnets[cidr.String()] == "" {
-		return fmt.Errorf("cannot get %s info", cidr.String())
-	}
+	return ipam.update(func() error {
+		if ipam.Subnets[cidr.String()] == "" {
+			return fmt.Errorf("cannot get %s info", cidr.String())
+		}
 
-	// 计算待释放ip相当于起始ip的偏移量
-	startIp := subnet.IP
-	i := 0
-	for j := 1; j <= 4; j++ {
-		// ip增量
-		diff := int([]byte(ip)[4-j] - []byte(startIp)[4-j])
-		i += diff
-	}
+		// 计算待释放ip相对于起始ip的偏移量
+		i := int(ipToUint32(ip)-ipToUint32(cidr.IP)) - 1
+		ipalloc := []byte(ipam.Subnets[cidr.String()])
+		// 最后两位对应广播地址和网段外的地址，不会被分配
+		if !cidr.Contains(ip) || i < 0 || i >= len(ipalloc)-2 {
+			return fmt.Errorf("ip %s is not in subnet %s", ip, cidr)
+		}
 
-	// 修改ip的分配信息
-	ipalloc := []byte(ipam.Subnets[cidr.String()])
-	ipalloc[i] = '0'
-	ipam.Subnets[cidr.String()] = string(ipalloc)
+		// 修改ip的分配信息
+		ipalloc[i] = '0'
+		ipam.Subnets[cidr.String()] = string(ipalloc)
+		return nil
+	})
+}
 
-	// 存储数据到文件
-	if err := ipam.dump(); err != nil {
-		return err
-	}
-	return nil
+// 将ipv4地址转换为整数
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+// 将整数转换为ipv4地址
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
 }
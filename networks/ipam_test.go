@@ -0,0 +1,97 @@
+package networks
+
+import (
+	"net"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestIPToUint32(t *testing.T) {
+	tests := []struct {
+		ip string
+		n  uint32
+	}{
+		{"0.0.0.0", 0},
+		{"10.0.0.1", 0x0a000001},
+		{"192.168.1.255", 0xc0a801ff},
+		{"255.255.255.255", 0xffffffff},
+	}
+	for _, tt := range tests {
+		if got := ipToUint32(net.ParseIP(tt.ip)); got != tt.n {
+			t.Errorf("ipToUint32(%s) = %#x, want %#x", tt.ip, got, tt.n)
+		}
+		if got := uint32ToIP(tt.n); !got.Equal(net.ParseIP(tt.ip)) {
+			t.Errorf("uint32ToIP(%#x) = %s, want %s", tt.n, got, tt.ip)
+		}
+	}
+}
+
+func newTestIPAM(t *testing.T) *IPAM {
+	return &IPAM{SubnetAllocatorPath: path.Join(t.TempDir(), "ipam", "subnet.json")}
+}
+
+func TestAllocateSkipsNetworkAndBroadcast(t *testing.T) {
+	ipam := newTestIPAM(t)
+	_, subnet, _ := net.ParseCIDR("10.1.0.0/29")
+
+	// /29共8个地址，去掉网络地址和广播地址后可以分配.1到.6
+	for i := 1; i <= 6; i++ {
+		ip, err := ipam.Allocate(subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := net.IPv4(10, 1, 0, byte(i)); !ip.Equal(want) {
+			t.Fatalf("allocate %d = %s, want %s", i, ip, want)
+		}
+	}
+	if ip, err := ipam.Allocate(subnet); err == nil {
+		t.Fatalf("allocate from full subnet = %s, want error", ip)
+	}
+
+	// 释放后重新分配到同一个地址
+	if err := ipam.Release(subnet, net.ParseIP("10.1.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	ip, err := ipam.Allocate(subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("10.1.0.3")) {
+		t.Fatalf("allocate after release = %s, want 10.1.0.3", ip)
+	}
+
+	for _, ip := range []string{"10.1.0.0", "10.1.0.7", "10.2.0.1"} {
+		if err := ipam.Release(subnet, net.ParseIP(ip)); err == nil {
+			t.Errorf("release %s succeeded, want error", ip)
+		}
+	}
+}
+
+func TestAllocateConcurrent(t *testing.T) {
+	ipam := newTestIPAM(t)
+	_, subnet, _ := net.ParseCIDR("10.2.0.0/24")
+
+	// 每个分配器独立加载文件，模拟多个命令同时分配
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ip, err := (&IPAM{SubnetAllocatorPath: ipam.SubnetAllocatorPath}).Allocate(subnet)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[ip.String()] {
+				t.Errorf("ip %s allocated twice", ip)
+			}
+			seen[ip.String()] = true
+		}()
+	}
+	wg.Wait()
+}
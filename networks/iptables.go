@@ -0,0 +1,96 @@
+package networks
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// 使用iptables命令的防火墙后端
+type IptablesFirewall struct {
+	// iptables命令的路径
+	path string
+}
+
+func newIptablesFirewall() (Firewall, error) {
+	path, err := exec.LookPath("iptables")
+	if err != nil {
+		return nil, err
+	}
+	fw := &IptablesFirewall{path: path}
+	// 检查iptables能否访问nat表，内核不支持时iptables命令仍然存在
+	if err := fw.run("-t", "nat", "-S", "POSTROUTING"); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+func (f *IptablesFirewall) Name() string {
+	return "iptables"
+}
+
+// 执行iptables命令
+func (f *IptablesFirewall) run(args ...string) error {
+	output, err := exec.Command(f.path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iptables %s err %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// 创建链，链已存在时-L成功
+func (f *IptablesFirewall) NewChain(chain string) error {
+	if f.run("-t", "nat", "-L", chain) == nil {
+		return nil
+	}
+	return f.run("-t", "nat", "-N", chain)
+}
+
+func (f *IptablesFirewall) HasRule(rule NatRule) bool {
+	return f.run(rule.iptablesArgs("-C")...) == nil
+}
+
+func (f *IptablesFirewall) AddRule(rule NatRule) error {
+	return f.run(rule.iptablesArgs("-A")...)
+}
+
+func (f *IptablesFirewall) DeleteRule(rule NatRule) error {
+	return f.run(rule.iptablesArgs("-D")...)
+}
+
+// 生成规则的iptables参数，action为-A、-C或-D
+func (r NatRule) iptablesArgs(action string) []string {
+	args := []string{"-t", "nat", action, r.Chain}
+	if r.Source != "" {
+		args = append(args, "-s", r.Source)
+	}
+	if r.Destination != "" {
+		args = append(args, "-d", r.Destination)
+	}
+	if r.NotDestination != "" {
+		args = append(args, "!", "-d", r.NotDestination)
+	}
+	if r.OutIface != "" {
+		args = append(args, "-o", r.OutIface)
+	}
+	if r.NotOutIface != "" {
+		args = append(args, "!", "-o", r.NotOutIface)
+	}
+	if r.DstType != "" {
+		args = append(args, "-m", "addrtype", "--dst-type", r.DstType)
+	}
+	if r.Protocol != "" {
+		args = append(args, "-p", r.Protocol, "-m", r.Protocol)
+	}
+	if r.DstPort != "" {
+		args = append(args, "--dport", r.DstPort)
+	}
+	if r.CtState != "" {
+		args = append(args, "-m", "conntrack", "--ctstate", r.CtState)
+	}
+	args = append(args, "-j", r.Target)
+	if r.ToDestination != "" {
+		args = append(args, "--to-destination", r.ToDestination)
+	}
+	return args
+}
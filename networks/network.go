@@ -12,11 +12,8 @@ import (
 	"go.uber.org/zap"
 )
 
-var logger *zap.Logger
-
-func init() {
-	logger, _ = zap.NewProduction()
-}
+// 包级变量先于所有init函数初始化，加载网络信息时即可使用日志
+var logger, _ = zap.NewProduction()
 
 type Network struct {
 	// 结构体实例的名称
@@ -25,6 +22,8 @@ type Network struct {
 	IpRange *net.IPNet
 	// 网络驱动名
 	Driver string
+	// 网络安装的iptables规则
+	Rules []NatRule
 }
 
 // 将网络的配置信息存储到文件
@@ -99,12 +98,18 @@ func (nw *Network) remove(remPath string) error {
 
 // 网络端点
 type EndPoint struct {
-	Id          string           `json:"id"`
-	Device      netlink.Veth     `json:"dev"`
-	IPAddress   net.IP           `json:"ip"`
-	MacAddress  net.HardwareAddr `json:"mac"`
-	PortMapping []string         `json:"portmapping"`
-	NetWork     *Network
+	Id        string `json:"id"`
+	Container string `json:"container"`
+	// veth只在连接网络时使用，持久化时只记录宿主机一端的名称
+	Device      netlink.Veth `json:"-"`
+	Veth        string       `json:"veth"`
+	IPAddress   net.IP       `json:"ip"`
+	MacAddress  string       `json:"mac"`
+	PortMapping []string     `json:"portmapping"`
+	Aliases     []string     `json:"aliases"`
+	Rules       []NatRule    `json:"rules"`
+	Proxies     []int        `json:"proxies"`
+	NetWork     *Network     `json:"-"`
 }
 
 // 创建网络
@@ -132,28 +137,53 @@ func CreateNetwork(driver, subnet, name string) error {
 	return nw.dump(DefaultNetworkPath)
 }
 
-// 运行容器时连接到指定网络
-func Connect(networkName string, name string, portMapping []string, pid int) error {
+// 运行容器时连接到指定网络，返回容器的网络端点。
+// 网络中的其他容器可以通过容器名和别名解析到该容器
+func Connect(networkName string, name string, aliases []string, portMapping []string, pid int) (*EndPoint, error) {
 	// 获取指定网络的信息
 	network, ok := networks[networkName]
 	if !ok {
-		return fmt.Errorf("no such network %s", networkName)
+		return nil, fmt.Errorf("no such network %s", networkName)
 	}
 
 	// 使用IPAM获取网段内的某个ip地址
 	ip, err := ipAllocator.Allocate(network.IpRange)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 创建网络端点
 	endpoint := &EndPoint{
-		Id:          fmt.Sprintf("%s-%s", name, networkName),
+		Id:          endpointId(name, networkName),
+		Container:   name,
 		IPAddress:   ip,
 		NetWork:     network,
 		PortMapping: portMapping,
+		Aliases:     aliases,
+	}
+
+	// 配置失败时释放已经分配的资源
+	if err := connectEndpoint(network, endpoint, pid); err != nil {
+		teardownEndpoint(network, endpoint)
+		return nil, err
 	}
+	// 记录网络端点，容器停止时据此释放资源
+	if err := endpoint.dump(); err != nil {
+		teardownEndpoint(network, endpoint)
+		return nil, err
+	}
+
+	// 添加容器的dns记录，dns服务出错时不影响容器的运行
+	if err := registerDNS(endpoint, append([]string{name}, aliases...)); err != nil {
+		logger.Sugar().Errorf("register dns of %s err %v", endpoint.Id, err)
+	} else if err := ensureDNSServer(network); err != nil {
+		logger.Sugar().Errorf("start dns server of network %s err %v", networkName, err)
+	}
+	return endpoint, nil
+}
 
+// 创建网络端点的veth，并配置容器中的网络和端口映射
+func connectEndpoint(network *Network, endpoint *EndPoint, pid int) error {
 	// 使用驱动连接网络端点和网络
 	if err := drivers[network.Driver].Connect(network, endpoint); err != nil {
 		return err
@@ -165,7 +195,10 @@ func Connect(networkName string, name string, portMapping []string, pid int) err
 	}
 
 	// 配置容器的端口映射
-	return configPortMapping(endpoint)
+	if err := configPortMapping(endpoint); err != nil {
+		return err
+	}
+	return startPortProxies(endpoint)
 }
 
 // 删除网络
@@ -177,16 +210,29 @@ func DeleteNetwork(networkName string) error {
 		return fmt.Errorf("no such network: %s", networkName)
 	}
 
+	// 仍有容器连接的网络不能删除
+	endpoints, err := listEndpoints(nw)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) > 0 {
+		return fmt.Errorf("network %s has %d active endpoints", networkName, len(endpoints))
+	}
+
 	// 释放网络的网关ip
 	if err := ipAllocator.Release(nw.IpRange, nw.IpRange.IP); err != nil {
 		return err
 	}
 
+	// 停止网络的dns服务
+	stopDNSServer(nw.Name)
+
 	// 使用驱动删除网络
 	if err := drivers[nw.Driver].Delete(nw); err != nil {
 		return err
 	}
 
 	// 删除网络的配置信息
+	os.RemoveAll(path.Join(DefaultEndpointPath, nw.Name))
 	return nw.remove(DefaultNetworkPath)
 }
@@ -0,0 +1,297 @@
+package networks
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// miniker在nftables中使用的表
+const NftablesTable = "miniker"
+
+// nftables没有内置链，在miniker的表中创建与iptables内置链同名的base chain
+var nftablesBaseChains = []*nftables.Chain{
+	{Name: "PREROUTING", Hooknum: nftables.ChainHookPrerouting, Priority: nftables.ChainPriorityNATDest, Type: nftables.ChainTypeNAT},
+	{Name: "OUTPUT", Hooknum: nftables.ChainHookOutput, Priority: nftables.ChainPriorityNATDest, Type: nftables.ChainTypeNAT},
+	{Name: "POSTROUTING", Hooknum: nftables.ChainHookPostrouting, Priority: nftables.ChainPriorityNATSource, Type: nftables.ChainTypeNAT},
+}
+
+// 连接跟踪状态中表示连接做过DNAT的标志位，即IPS_DST_NAT
+const ctStatusDNAT = 1 << 5
+
+// 通过netlink操作nftables的防火墙后端，用于只有nftables的宿主机
+type NftablesFirewall struct {
+	table *nftables.Table
+}
+
+func newNftablesFirewall() (Firewall, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		return nil, err
+	}
+	// 检查内核是否支持nftables
+	if _, err := conn.ListTablesOfFamily(nftables.TableFamilyIPv4); err != nil {
+		return nil, fmt.Errorf("nftables err %v", err)
+	}
+	return &NftablesFirewall{
+		table: &nftables.Table{Name: NftablesTable, Family: nftables.TableFamilyIPv4},
+	}, nil
+}
+
+func (f *NftablesFirewall) Name() string {
+	return "nftables"
+}
+
+// 创建链，同时保证miniker的表和base chain存在。nftables创建已存在的表和链时不会报错
+func (f *NftablesFirewall) NewChain(chain string) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	conn.AddTable(f.table)
+	for _, base := range nftablesBaseChains {
+		c := *base
+		c.Table = f.table
+		conn.AddChain(&c)
+	}
+	conn.AddChain(f.chain(chain))
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("nftables add chain %s err %v", chain, err)
+	}
+	return nil
+}
+
+func (f *NftablesFirewall) chain(name string) *nftables.Chain {
+	return &nftables.Chain{Name: name, Table: f.table}
+}
+
+// 查找链中与规则对应的nftables规则，规则的文本形式保存在UserData中
+func (f *NftablesFirewall) findRules(conn *nftables.Conn, rule NatRule) ([]*nftables.Rule, error) {
+	rules, err := conn.GetRules(f.table, f.chain(rule.Chain))
+	if err != nil {
+		return nil, err
+	}
+	userData := []byte(rule.String())
+	var found []*nftables.Rule
+	for _, r := range rules {
+		if bytes.Equal(r.UserData, userData) {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func (f *NftablesFirewall) HasRule(rule NatRule) bool {
+	conn, err := nftables.New()
+	if err != nil {
+		return false
+	}
+	// 链不存在时返回错误，规则也就不存在
+	found, err := f.findRules(conn, rule)
+	return err == nil && len(found) > 0
+}
+
+func (f *NftablesFirewall) AddRule(rule NatRule) error {
+	exprs, err := rule.nftablesExprs()
+	if err != nil {
+		return err
+	}
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	conn.AddRule(&nftables.Rule{
+		Table:    f.table,
+		Chain:    f.chain(rule.Chain),
+		Exprs:    exprs,
+		UserData: []byte(rule.String()),
+	})
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("nftables add rule %s err %v", rule, err)
+	}
+	return nil
+}
+
+func (f *NftablesFirewall) DeleteRule(rule NatRule) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	found, err := f.findRules(conn, rule)
+	if err != nil {
+		return err
+	}
+	for _, r := range found {
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("nftables delete rule %s err %v", rule, err)
+	}
+	return nil
+}
+
+// 将规则翻译成nftables表达式
+func (r NatRule) nftablesExprs() ([]expr.Any, error) {
+	var exprs []expr.Any
+	// ip saddr <subnet>
+	if r.Source != "" {
+		addrExprs, err := addressExprs(12, r.Source, expr.CmpOpEq)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, addrExprs...)
+	}
+	// ip daddr <subnet>
+	if r.Destination != "" {
+		addrExprs, err := addressExprs(16, r.Destination, expr.CmpOpEq)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, addrExprs...)
+	}
+	// ip daddr != <subnet>
+	if r.NotDestination != "" {
+		addrExprs, err := addressExprs(16, r.NotDestination, expr.CmpOpNeq)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, addrExprs...)
+	}
+	// oifname <iface>
+	if r.OutIface != "" {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(r.OutIface)},
+		)
+	}
+	// oifname != <iface>
+	if r.NotOutIface != "" {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: ifname(r.NotOutIface)},
+		)
+	}
+	// fib daddr type local
+	if r.DstType != "" {
+		if r.DstType != "LOCAL" {
+			return nil, fmt.Errorf("unsupported address type %s", r.DstType)
+		}
+		exprs = append(exprs,
+			&expr.Fib{Register: 1, FlagDADDR: true, ResultADDRTYPE: true},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(unix.RTN_LOCAL)},
+		)
+	}
+	// meta l4proto <protocol>
+	if r.Protocol != "" {
+		proto, err := protocolNumber(r.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+		)
+	}
+	// th dport <port>
+	if r.DstPort != "" {
+		port, err := strconv.ParseUint(r.DstPort, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", r.DstPort)
+		}
+		exprs = append(exprs,
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(uint16(port))},
+		)
+	}
+	// ct status dnat
+	if r.CtState != "" {
+		if r.CtState != "DNAT" {
+			return nil, fmt.Errorf("unsupported conntrack state %s", r.CtState)
+		}
+		exprs = append(exprs,
+			&expr.Ct{Key: expr.CtKeySTATUS, Register: 1},
+			&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: binaryutil.NativeEndian.PutUint32(ctStatusDNAT), Xor: make([]byte, 4)},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: make([]byte, 4)},
+		)
+	}
+
+	switch r.Target {
+	case "MASQUERADE":
+		exprs = append(exprs, &expr.Masq{})
+	case "DNAT":
+		natExprs, err := dnatExprs(r.ToDestination)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, natExprs...)
+	default:
+		// 其余的动作是跳转到miniker的链
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictJump, Chain: r.Target})
+	}
+	return exprs, nil
+}
+
+// 比较ip头中offset处的地址与网段，op为CmpOpEq时匹配属于网段的地址
+func addressExprs(offset uint32, cidr string, op expr.CmpOp) ([]expr.Any, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: subnet.Mask, Xor: make([]byte, 4)},
+		&expr.Cmp{Op: op, Register: 1, Data: subnet.IP.To4()},
+	}, nil
+}
+
+// dnat to <ip>[:<port>]
+func dnatExprs(toDestination string) ([]expr.Any, error) {
+	host, port := toDestination, ""
+	if i := strings.LastIndex(toDestination, ":"); i >= 0 {
+		host, port = toDestination[:i], toDestination[i+1:]
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid DNAT destination %s", toDestination)
+	}
+	exprs := []expr.Any{&expr.Immediate{Register: 1, Data: ip}}
+	nat := &expr.NAT{Type: expr.NATTypeDestNAT, Family: unix.NFPROTO_IPV4, RegAddrMin: 1}
+	if port != "" {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNAT destination %s", toDestination)
+		}
+		exprs = append(exprs, &expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(uint16(p))})
+		nat.RegProtoMin = 2
+	}
+	return append(exprs, nat), nil
+}
+
+// 网络接口名在nftables中以IFNAMSIZ长度比较
+func ifname(name string) []byte {
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return b
+}
+
+// 协议名对应的协议号
+func protocolNumber(protocol string) (byte, error) {
+	switch protocol {
+	case "tcp":
+		return unix.IPPROTO_TCP, nil
+	case "udp":
+		return unix.IPPROTO_UDP, nil
+	case "sctp":
+		return unix.IPPROTO_SCTP, nil
+	}
+	return 0, fmt.Errorf("unsupported protocol %s", protocol)
+}
@@ -0,0 +1,163 @@
+package networks
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 端口映射支持的协议，默认为tcp
+var portProtocols = map[string]bool{"tcp": true, "udp": true, "sctp": true}
+
+// 端口映射，格式为[hostIP:]hostPort[-range]:containerPort[-range][/tcp|udp|sctp]。
+// 端口范围的长度必须相同，宿主机端口按顺序映射到容器端口
+type PortMapping struct {
+	// 为空时映射宿主机所有地址上的端口
+	HostIP        net.IP
+	HostPort      int
+	HostPortEnd   int
+	ContainerPort int
+	// 单个端口时与起始端口相同
+	ContainerPortEnd int
+	Protocol         string
+}
+
+// 解析端口映射
+func ParsePortMapping(spec string) (*PortMapping, error) {
+	pm := &PortMapping{Protocol: "tcp"}
+	ports := spec
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		ports, pm.Protocol = spec[:i], strings.ToLower(spec[i+1:])
+		if !portProtocols[pm.Protocol] {
+			return nil, fmt.Errorf("invalid protocol %q in port mapping %s", spec[i+1:], spec)
+		}
+	}
+
+	parts := strings.Split(ports, ":")
+	switch len(parts) {
+	case 2:
+	case 3:
+		if parts[0] != "" {
+			pm.HostIP = net.ParseIP(parts[0]).To4()
+			if pm.HostIP == nil {
+				return nil, fmt.Errorf("invalid host ip %q in port mapping %s", parts[0], spec)
+			}
+		}
+		parts = parts[1:]
+	default:
+		return nil, fmt.Errorf("port mapping %s should be [hostIP:]hostPort[-range]:containerPort[-range][/protocol]", spec)
+	}
+
+	var err error
+	if pm.HostPort, pm.HostPortEnd, err = parsePortRange(parts[0]); err != nil {
+		return nil, fmt.Errorf("invalid host port in port mapping %s, %v", spec, err)
+	}
+	if pm.ContainerPort, pm.ContainerPortEnd, err = parsePortRange(parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid container port in port mapping %s, %v", spec, err)
+	}
+	if pm.HostPortEnd-pm.HostPort != pm.ContainerPortEnd-pm.ContainerPort {
+		return nil, fmt.Errorf("host and container port ranges of port mapping %s have different sizes", spec)
+	}
+	return pm, nil
+}
+
+// 解析端口或端口范围，如80、8000-8010
+func parsePortRange(s string) (int, int, error) {
+	start, end := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		start, end = s[:i], s[i+1:]
+	}
+	startPort, err := parsePort(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	endPort, err := parsePort(end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if startPort > endPort {
+		return 0, 0, fmt.Errorf("invalid port range %s", s)
+	}
+	return startPort, endPort, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// 端口映射的规范形式，可以再次解析
+func (pm *PortMapping) String() string {
+	return fmt.Sprintf("%s%s:%s/%s", pm.hostIPPrefix(), portRange(pm.HostPort, pm.HostPortEnd), portRange(pm.ContainerPort, pm.ContainerPortEnd), pm.Protocol)
+}
+
+// ps中显示的形式，如0.0.0.0:8080->80/tcp
+func (pm *PortMapping) Published() string {
+	hostIP := "0.0.0.0:"
+	if pm.HostIP != nil {
+		hostIP = pm.hostIPPrefix()
+	}
+	return fmt.Sprintf("%s%s->%s/%s", hostIP, portRange(pm.HostPort, pm.HostPortEnd), portRange(pm.ContainerPort, pm.ContainerPortEnd), pm.Protocol)
+}
+
+func (pm *PortMapping) hostIPPrefix() string {
+	if pm.HostIP == nil {
+		return ""
+	}
+	return pm.HostIP.String() + ":"
+}
+
+func portRange(start, end int) string {
+	if start == end {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d-%d", start, end)
+}
+
+// 两个端口映射是否占用了宿主机上相同的端口
+func (pm *PortMapping) overlaps(other *PortMapping) bool {
+	if pm.Protocol != other.Protocol {
+		return false
+	}
+	if pm.HostIP != nil && other.HostIP != nil && !pm.HostIP.Equal(other.HostIP) {
+		return false
+	}
+	return pm.HostPort <= other.HostPortEnd && other.HostPort <= pm.HostPortEnd
+}
+
+// 检查一组端口映射，返回它们的规范形式。容器启动前调用，拒绝格式错误和相互冲突的映射
+func ValidatePortMappings(specs []string) ([]string, error) {
+	var mappings []*PortMapping
+	var normalized []string
+	for _, spec := range specs {
+		pm, err := ParsePortMapping(spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range mappings {
+			if pm.overlaps(other) {
+				return nil, fmt.Errorf("port mappings %s and %s publish the same host port", other, pm)
+			}
+		}
+		mappings = append(mappings, pm)
+		normalized = append(normalized, pm.String())
+	}
+	return normalized, nil
+}
+
+// 以ps中显示的形式列出端口映射，无法解析的映射原样显示
+func PublishedPorts(specs []string) string {
+	var ports []string
+	for _, spec := range specs {
+		if pm, err := ParsePortMapping(spec); err == nil {
+			ports = append(ports, pm.Published())
+		} else {
+			ports = append(ports, spec)
+		}
+	}
+	return strings.Join(ports, ", ")
+}
@@ -0,0 +1,108 @@
+package networks
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    *PortMapping
+		wantErr bool
+	}{
+		{spec: "8080:80", want: &PortMapping{HostPort: 8080, HostPortEnd: 8080, ContainerPort: 80, ContainerPortEnd: 80, Protocol: "tcp"}},
+		{spec: "127.0.0.1:53:53/udp", want: &PortMapping{HostIP: net.ParseIP("127.0.0.1").To4(), HostPort: 53, HostPortEnd: 53, ContainerPort: 53, ContainerPortEnd: 53, Protocol: "udp"}},
+		{spec: "8000-8010:9000-9010/sctp", want: &PortMapping{HostPort: 8000, HostPortEnd: 8010, ContainerPort: 9000, ContainerPortEnd: 9010, Protocol: "sctp"}},
+		{spec: ":8080:80", want: &PortMapping{HostPort: 8080, HostPortEnd: 8080, ContainerPort: 80, ContainerPortEnd: 80, Protocol: "tcp"}},
+		{spec: "8080:80/UDP", want: &PortMapping{HostPort: 8080, HostPortEnd: 8080, ContainerPort: 80, ContainerPortEnd: 80, Protocol: "udp"}},
+		{spec: "8000-8010:9000-9005", wantErr: true},
+		{spec: "8000-8010:80", wantErr: true},
+		{spec: "127.0.0.1::80", wantErr: true},
+		{spec: "::1:8080:80", wantErr: true},
+		{spec: "[::1]:8080:80", wantErr: true},
+		{spec: "8080:80/icmp", wantErr: true},
+		{spec: "80", wantErr: true},
+		{spec: "0:80", wantErr: true},
+		{spec: "8080:65536", wantErr: true},
+		{spec: "8010-8000:9010-9000", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortMapping(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePortMapping(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePortMapping(%q) err %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePortMapping(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestPortMappingString(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{spec: "8080:80", want: "8080:80/tcp"},
+		{spec: ":8080:80/UDP", want: "8080:80/udp"},
+		{spec: "127.0.0.1:8000-8010:9000-9010/sctp", want: "127.0.0.1:8000-8010:9000-9010/sctp"},
+		{spec: "8000-8000:9000-9000", want: "8000:9000/tcp"},
+	}
+	for _, tt := range tests {
+		pm, err := ParsePortMapping(tt.spec)
+		if err != nil {
+			t.Fatalf("ParsePortMapping(%q) err %v", tt.spec, err)
+		}
+		if got := pm.String(); got != tt.want {
+			t.Errorf("String() of %q = %q, want %q", tt.spec, got, tt.want)
+		}
+		// 规范形式再次解析后不变
+		again, err := ParsePortMapping(pm.String())
+		if err != nil {
+			t.Fatalf("ParsePortMapping(%q) err %v", pm.String(), err)
+		}
+		if !reflect.DeepEqual(again, pm) {
+			t.Errorf("round trip of %q = %+v, want %+v", tt.spec, again, pm)
+		}
+	}
+}
+
+func TestValidatePortMappings(t *testing.T) {
+	tests := []struct {
+		specs   []string
+		want    []string
+		wantErr bool
+	}{
+		{specs: []string{"8080:80", "8080:80/udp"}, want: []string{"8080:80/tcp", "8080:80/udp"}},
+		{specs: []string{"127.0.0.1:8080:80", "127.0.0.2:8080:80"}, want: []string{"127.0.0.1:8080:80/tcp", "127.0.0.2:8080:80/tcp"}},
+		{specs: []string{"8000-8010:9000-9010/udp", "8011:80/udp"}, want: []string{"8000-8010:9000-9010/udp", "8011:80/udp"}},
+		{specs: []string{"8080:80", "8080:81"}, wantErr: true},
+		{specs: []string{"8000-8010:9000-9010", "8005:80/tcp"}, wantErr: true},
+		{specs: []string{"127.0.0.1:8080:80", "8080:81"}, wantErr: true},
+		{specs: []string{"8080:80", "bad"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ValidatePortMappings(tt.specs)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ValidatePortMappings(%q) = %q, want error", tt.specs, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ValidatePortMappings(%q) err %v", tt.specs, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ValidatePortMappings(%q) = %q, want %q", tt.specs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package networks
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// udp代理为每个客户端创建到容器的socket，空闲超过该时间后关闭
+const udpProxyTimeout = 90 * time.Second
+
+// 代理进程从该文件描述符开始依次接收监听的socket，与端口范围中的端口一一对应
+const proxyListenFd = 3
+
+// 为网络端点的映射端口启动用户态代理。127.0.0.0/8上的连接不经过DNAT规则，
+// 宿主机通过127.0.0.1访问映射端口时由代理转发到容器。
+// 监听的socket由当前进程创建后传给代理进程，端口被占用时连接网络失败
+func startPortProxies(endpoint *EndPoint) error {
+	for _, spec := range endpoint.PortMapping {
+		pm, err := ParsePortMapping(spec)
+		if err != nil {
+			return err
+		}
+		// 标准库不支持sctp，sctp端口只能通过DNAT规则访问
+		if pm.Protocol == "sctp" {
+			logger.Sugar().Warnf("userland proxy does not support sctp, skip %s", pm)
+			continue
+		}
+		pid, err := startPortProxy(endpoint, pm)
+		if err != nil {
+			return err
+		}
+		endpoint.Proxies = append(endpoint.Proxies, pid)
+	}
+	return nil
+}
+
+// 启动一个端口映射的代理进程，返回进程的pid
+func startPortProxy(endpoint *EndPoint, pm *PortMapping) (int, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for port := pm.HostPort; port <= pm.HostPortEnd; port++ {
+		f, err := listenFile(pm.Protocol, proxyHostAddr(pm.HostIP, port))
+		if err != nil {
+			return 0, fmt.Errorf("publish port %s err %v", pm, err)
+		}
+		files = append(files, f)
+	}
+
+	if err := os.MkdirAll(DefaultProxyPath, 0755); err != nil {
+		return 0, err
+	}
+	logFile, err := os.OpenFile(proxyLogPath(endpoint.Id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	// 代理作为独立的会话运行，不随当前命令退出
+	cmd := exec.Command("/proc/self/exe", "network", "proxy", endpoint.Id, pm.String(), endpoint.IPAddress.String())
+	cmd.ExtraFiles = files
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// 监听宿主机的端口，返回socket对应的文件
+func listenFile(protocol, addr string) (*os.File, error) {
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.(*net.UDPConn).File()
+	}
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	return l.(*net.TCPListener).File()
+}
+
+// 代理监听的地址，未指定宿主机地址时监听所有地址
+func proxyHostAddr(hostIP net.IP, port int) string {
+	host := ""
+	if hostIP != nil {
+		host = hostIP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func proxyLogPath(endpointId string) string {
+	return path.Join(DefaultProxyPath, endpointId+".log")
+}
+
+// 停止网络端点的代理进程
+func stopPortProxies(endpoint *EndPoint) error {
+	var firstErr error
+	for _, pid := range endpoint.Proxies {
+		// 进程已经退出时pid可能被其他进程复用
+		if !isPortProxy(pid, endpoint.Id) {
+			continue
+		}
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH && firstErr == nil {
+			firstErr = fmt.Errorf("stop proxy %d err %v", pid, err)
+		}
+	}
+	if err := os.Remove(proxyLogPath(endpoint.Id)); err != nil && !os.IsNotExist(err) && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// 检查进程是否是网络端点的代理进程
+func isPortProxy(pid int, endpointId string) bool {
+	args := proxyArgs(pid)
+	return args != nil && args[0] == endpointId
+}
+
+// 读取代理进程的参数：网络端点id、端口映射和容器ip，进程不是代理时返回nil
+func proxyArgs(pid int) []string {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil
+	}
+	args := strings.Split(string(cmdline), "\x00")
+	if len(args) < 6 || args[1] != "network" || args[2] != "proxy" {
+		return nil
+	}
+	return args[3:6]
+}
+
+// 检查网络端点的代理进程，重新启动已经退出的代理
+func ensurePortProxies(endpoint *EndPoint) error {
+	running := map[string]int{}
+	for _, pid := range endpoint.Proxies {
+		if args := proxyArgs(pid); args != nil && args[0] == endpoint.Id {
+			running[args[1]] = pid
+		}
+	}
+
+	var pids []int
+	var firstErr error
+	changed := len(running) != len(endpoint.Proxies)
+	for _, spec := range endpoint.PortMapping {
+		pm, err := ParsePortMapping(spec)
+		if err != nil {
+			return err
+		}
+		if pm.Protocol == "sctp" {
+			continue
+		}
+		if pid, ok := running[pm.String()]; ok {
+			pids = append(pids, pid)
+			continue
+		}
+		logger.Sugar().Warnf("proxy of %s in endpoint %s is not running, restarting", pm, endpoint.Id)
+		pid, err := startPortProxy(endpoint, pm)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		pids = append(pids, pid)
+		changed = true
+	}
+	if changed {
+		endpoint.Proxies = pids
+		if err := endpoint.dump(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 运行端口映射的代理，将宿主机端口上的连接转发到容器
+func ServePortProxy(endpointId, spec, containerIP string) error {
+	pm, err := ParsePortMapping(spec)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(containerIP)
+	if ip == nil {
+		return fmt.Errorf("invalid container ip %s", containerIP)
+	}
+
+	errCh := make(chan error, 1)
+	for offset := 0; pm.HostPort+offset <= pm.HostPortEnd; offset++ {
+		f := os.NewFile(uintptr(proxyListenFd+offset), "proxy")
+		backend := net.JoinHostPort(ip.String(), strconv.Itoa(pm.ContainerPort+offset))
+		if pm.Protocol == "udp" {
+			conn, err := net.FilePacketConn(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			backendAddr, err := net.ResolveUDPAddr("udp", backend)
+			if err != nil {
+				return err
+			}
+			go func() { errCh <- proxyUDP(conn, backendAddr) }()
+		} else {
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			go func() { errCh <- proxyTCP(l, backend) }()
+		}
+	}
+	logger.Sugar().Infof("proxy of endpoint %s forwarding %s to %s", endpointId, pm, containerIP)
+	return <-errCh
+}
+
+// 接受tcp连接并转发到容器
+func proxyTCP(l net.Listener, backend string) error {
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer client.Close()
+			server, err := net.Dial("tcp", backend)
+			if err != nil {
+				logger.Sugar().Errorf("dial %s err %v", backend, err)
+				return
+			}
+			defer server.Close()
+
+			// 一个方向的数据传输结束后关闭对端的写，等待两个方向都结束
+			done := make(chan struct{}, 2)
+			pipe := func(dst, src net.Conn) {
+				io.Copy(dst, src)
+				if conn, ok := dst.(*net.TCPConn); ok {
+					conn.CloseWrite()
+				}
+				done <- struct{}{}
+			}
+			go pipe(server, client)
+			go pipe(client, server)
+			<-done
+			<-done
+		}()
+	}
+}
+
+// 转发udp报文，每个客户端使用一个到容器的socket，容器的回包通过该socket发回客户端
+func proxyUDP(conn net.PacketConn, backend *net.UDPAddr) error {
+	var mu sync.Mutex
+	clients := map[string]*net.UDPConn{}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		server, ok := clients[addr.String()]
+		if !ok {
+			server, err = net.DialUDP("udp", nil, backend)
+			if err != nil {
+				mu.Unlock()
+				logger.Sugar().Errorf("dial %s err %v", backend, err)
+				continue
+			}
+			clients[addr.String()] = server
+			go func(addr net.Addr, server *net.UDPConn) {
+				reply := make([]byte, 65535)
+				for {
+					n, err := server.Read(reply)
+					if err != nil {
+						break
+					}
+					server.SetReadDeadline(time.Now().Add(udpProxyTimeout))
+					conn.WriteTo(reply[:n], addr)
+				}
+				mu.Lock()
+				delete(clients, addr.String())
+				mu.Unlock()
+				server.Close()
+			}(addr, server)
+		}
+		mu.Unlock()
+		// 客户端或容器发送数据时延长空闲时间
+		server.SetReadDeadline(time.Now().Add(udpProxyTimeout))
+		server.Write(buf[:n])
+	}
+}
@@ -0,0 +1,178 @@
+package networks
+
+import (
+	"net"
+	"os"
+	"path"
+
+	"github.com/vishvananda/netlink"
+)
+
+// 将旧版本/var/run下的网络和ip分配信息迁移到持久化的目录，已存在的文件不会被覆盖
+func migrateLegacyState() {
+	files := map[string]string{LegacyIpamAllocatorPath: DefaultIpamAllocatorPath}
+	if entries, err := os.ReadDir(LegacyNetworkPath); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files[path.Join(LegacyNetworkPath, entry.Name())] = path.Join(DefaultNetworkPath, entry.Name())
+			}
+		}
+	}
+	for oldPath, newPath := range files {
+		if _, err := os.Stat(newPath); err == nil {
+			continue
+		}
+		content, err := os.ReadFile(oldPath)
+		if err != nil {
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(newPath), 0755); err != nil {
+			logger.Sugar().Errorf("migrate %s err %v", oldPath, err)
+			continue
+		}
+		if err := os.WriteFile(newPath, content, 0644); err != nil {
+			logger.Sugar().Errorf("migrate %s err %v", oldPath, err)
+			continue
+		}
+		logger.Sugar().Infof("migrate %s to %s", oldPath, newPath)
+	}
+}
+
+// 检查保存的网络与宿主机的实际状态是否一致：重新创建缺失的bridge和NAT规则，
+// 并清理容器已经退出的网络端点。宿主机重启后网络因此可以恢复
+func Reconcile() {
+	for _, nw := range networks {
+		driver, ok := drivers[nw.Driver]
+		if !ok {
+			logger.Sugar().Warnf("network %s uses unknown driver %s", nw.Name, nw.Driver)
+			continue
+		}
+		if err := driver.Reconcile(nw); err != nil {
+			logger.Sugar().Errorf("reconcile network %s err %v", nw.Name, err)
+			continue
+		}
+		reconcileEndpoints(nw)
+	}
+}
+
+// 清理veth已经不存在的网络端点，释放其占用的ip和dns记录。
+// 仍在使用的网络端点重新安装缺失的规则，并重启已经退出的端口代理
+func reconcileEndpoints(nw *Network) {
+	endpoints, err := listEndpoints(nw)
+	if err != nil {
+		logger.Sugar().Errorf("list endpoints of network %s err %v", nw.Name, err)
+		return
+	}
+	for _, ep := range endpoints {
+		if _, err := netlink.LinkByName(ep.Veth); err == nil {
+			if err := ensureNatRules(ep.Rules); err != nil {
+				logger.Sugar().Errorf("reconcile rules of endpoint %s err %v", ep.Id, err)
+			}
+			if err := ensurePortProxies(ep); err != nil {
+				logger.Sugar().Errorf("reconcile proxies of endpoint %s err %v", ep.Id, err)
+			}
+			continue
+		} else if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			logger.Sugar().Errorf("get veth %s err %v", ep.Veth, err)
+			continue
+		}
+		logger.Sugar().Warnf("endpoint %s in network %s is stale, releasing %s", ep.Id, nw.Name, ep.IPAddress)
+		teardownEndpoint(nw, ep)
+	}
+}
+
+// 检查bridge、网关地址和网络记录的NAT规则，缺失时重新创建
+func (b *BridgeNetworkDriver) Reconcile(nw *Network) error {
+	bridgeName := nw.Name
+	br, err := netlink.LinkByName(bridgeName)
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		// 重新创建bridge后，继续检查其地址、状态和NAT规则
+		logger.Sugar().Warnf("bridge %s of network %s is missing, recreating", bridgeName, nw.Name)
+		if err := createBridgeInterface(bridgeName); err != nil {
+			return err
+		}
+		br, err = netlink.LinkByName(bridgeName)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 检查bridge的网关地址
+	addrs, err := netlink.AddrList(br, netlink.FAMILY_V4)
+	if err != nil {
+		return err
+	}
+	hasGateway := false
+	for _, addr := range addrs {
+		if addr.IPNet.String() == nw.IpRange.String() {
+			hasGateway = true
+			break
+		}
+	}
+	if !hasGateway {
+		logger.Sugar().Warnf("bridge %s has no gateway address %s, adding", bridgeName, nw.IpRange)
+		if err := setInterfaceIP(bridgeName, nw.IpRange.String()); err != nil {
+			return err
+		}
+	}
+
+	if br.Attrs().Flags&net.FlagUp == 0 {
+		logger.Sugar().Warnf("bridge %s is down, setting up", bridgeName)
+		if err := setInterfaceUP(bridgeName); err != nil {
+			return err
+		}
+	}
+
+	// 旧版本没有记录规则，MASQUERADE规则直接安装在POSTROUTING链中，迁移到miniker的链
+	if nw.Rules == nil {
+		legacy := masqueradeRule(bridgeName, nw.IpRange)
+		legacy.Chain = "POSTROUTING"
+		if err := deleteNatRule(legacy); err != nil {
+			logger.Sugar().Warnf("delete legacy MASQUERADE rule of network %s err %v", nw.Name, err)
+		}
+	}
+	// 补充旧版本创建的网络缺少的规则
+	changed := false
+	for _, rule := range bridgeNatRules(bridgeName, nw.IpRange) {
+		if !hasRecordedRule(nw.Rules, rule) {
+			nw.Rules = append(nw.Rules, rule)
+			changed = true
+		}
+	}
+	if changed {
+		if err := nw.dump(DefaultNetworkPath); err != nil {
+			return err
+		}
+	}
+	return ensureNatRules(nw.Rules)
+}
+
+// 规则是否已经记录
+func hasRecordedRule(rules []NatRule, rule NatRule) bool {
+	for _, r := range rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// 重新安装缺失的规则，同时保证miniker的链及其跳转规则存在
+func ensureNatRules(rules []NatRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	if err := ensureChains(); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if natRuleExists(rule) {
+			continue
+		}
+		logger.Sugar().Warnf("nat rule %s is missing, adding", rule)
+		if err := addNatRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
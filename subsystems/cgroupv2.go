@@ -0,0 +1,140 @@
+package subsystems
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+const (
+	// cgroup v2统一层级的默认挂载点
+	defaultCgroupV2Root = "/sys/fs/cgroup"
+	// cgroup2文件系统的magic number，见linux/magic.h
+	cgroup2SuperMagic = 0x63677270
+)
+
+// 判断当前主机是否只使用cgroup v2统一层级
+// 混合模式下/sys/fs/cgroup是tmpfs，v2只挂载在子目录中，此时仍使用v1
+func IsCgroupV2() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(defaultCgroupV2Root, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+// 获取cgroup2文件系统的挂载点
+func findCgroupV2MountPoint() string {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		logger.Sugar().Error(err)
+		return defaultCgroupV2Root
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// mountinfo中" - "之后的第一个字段为文件系统类型
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[0])
+		optional := strings.Fields(parts[1])
+		if len(fields) > 4 && len(optional) > 0 && optional[0] == "cgroup2" {
+			return fields[4]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		logger.Sugar().Error(err)
+	}
+	return ""
+}
+
+// 获取cgroup v2中`cgroup`的绝对路径
+// 创建cgroup时会在所有祖先节点的cgroup.subtree_control中开启`controller`
+func getCgroupV2Path(controller string, cgroup string, autoCreate bool) (string, error) {
+	root := findCgroupV2MountPoint()
+	if root == "" {
+		return "", fmt.Errorf("cannot find cgroup2 mount point")
+	}
+	cgroupPath := path.Join(root, cgroup)
+	if _, err := os.Stat(cgroupPath); err != nil {
+		if !autoCreate || !os.IsNotExist(err) {
+			return "", fmt.Errorf("error get cgroup path %v", err)
+		}
+		if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+			return "", fmt.Errorf("error create cgroup %v", err)
+		}
+	}
+
+	if controller != "" {
+		if err := enableCgroupV2Controller(root, cgroup, controller); err != nil {
+			return "", err
+		}
+	}
+	return cgroupPath, nil
+}
+
+// 从根节点开始，逐级在cgroup.subtree_control中开启控制器
+// 只有父节点开启了控制器，子节点才会出现对应的接口文件
+func enableCgroupV2Controller(root string, cgroup string, controller string) error {
+	current := root
+	for _, dir := range strings.Split(path.Dir(path.Clean("/"+cgroup)), "/") {
+		if dir != "" {
+			current = path.Join(current, dir)
+		}
+		controlFile := path.Join(current, "cgroup.subtree_control")
+		enabled, err := os.ReadFile(controlFile)
+		if err != nil {
+			return fmt.Errorf("error read %s %v", controlFile, err)
+		}
+		if containsField(string(enabled), controller) {
+			continue
+		}
+		if err := os.WriteFile(controlFile, []byte("+"+controller), 0644); err != nil {
+			return fmt.Errorf("error enable controller %s in %s %v", controller, controlFile, err)
+		}
+	}
+	return nil
+}
+
+func containsField(content string, field string) bool {
+	for _, f := range strings.Fields(content) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// 将进程加入cgroup v2
+func applyCgroupV2(cgroup string, pid int) error {
+	cgroupPath, err := getCgroupV2Path("", cgroup, false)
+	if err != nil {
+		return err
+	}
+
+	procsFileName := path.Join(cgroupPath, "cgroup.procs")
+	if err := os.WriteFile(procsFileName, []byte(fmt.Sprintf("%d", pid)), 0644); err != nil {
+		return fmt.Errorf("error apply cgroup %v", err)
+	}
+	return nil
+}
+
+// 删除cgroup v2目录
+// 统一层级中所有控制器共享同一个目录，目录已被删除时直接返回
+func removeCgroupV2(cgroup string) error {
+	root := findCgroupV2MountPoint()
+	if root == "" {
+		return fmt.Errorf("cannot find cgroup2 mount point")
+	}
+	if err := os.Remove(path.Join(root, cgroup)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error remove directory %v", err)
+	}
+	return nil
+}
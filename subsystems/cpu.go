@@ -27,7 +27,7 @@ func (c *CpuSubsystem) Set(cgroup string, cfg *SubsystemConfig) error {
 	// 假设cgroup A被设置为1024，cgroup B被设置为512,
 	// 则A能使用66.66%的cpu资源，B能使用33.33%的cpu资源。
 	limitFileName := path.Join(subsystemCgroupRoot, "cpu.shares")
-	if err := os.WriteFile(limitFileName, []byte(cfg.MemLimit), 0644); err != nil {
+	if err := os.WriteFile(limitFileName, []byte(cfg.CpuShare), 0644); err != nil {
 		return fmt.Errorf("error set cgroup %v", err)
 	}
 	return nil
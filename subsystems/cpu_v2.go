@@ -0,0 +1,60 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+)
+
+// cgroup v2的cpu控制器
+type CpuV2Subsystem struct{}
+
+func (c *CpuV2Subsystem) Name() string {
+	return "cpu"
+}
+
+func (c *CpuV2Subsystem) Set(cgroup string, cfg *SubsystemConfig) error {
+	subsystemCgroupRoot, err := getCgroupV2Path(c.Name(), cgroup, true)
+	if err != nil {
+		return err
+	}
+
+	if cfg.CpuShare == "" {
+		return nil
+	}
+
+	weight, err := cpuSharesToWeight(cfg.CpuShare)
+	if err != nil {
+		return err
+	}
+	limitFileName := path.Join(subsystemCgroupRoot, "cpu.weight")
+	if err := os.WriteFile(limitFileName, []byte(strconv.FormatUint(weight, 10)), 0644); err != nil {
+		return fmt.Errorf("error set cgroup %v", err)
+	}
+	return nil
+}
+
+func (c *CpuV2Subsystem) Apply(cgroup string, pid int) error {
+	return applyCgroupV2(cgroup, pid)
+}
+
+func (c *CpuV2Subsystem) Remove(cgroup string) error {
+	return removeCgroupV2(cgroup)
+}
+
+// 将v1的cpu.shares换算为v2的cpu.weight
+// cpu.shares的取值范围为[2, 262144]，cpu.weight的取值范围为[1, 10000]
+func cpuSharesToWeight(cpuShare string) (uint64, error) {
+	shares, err := strconv.ParseUint(cpuShare, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu shares %s %v", cpuShare, err)
+	}
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142, nil
+}
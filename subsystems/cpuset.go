@@ -22,7 +22,7 @@ func (c *CpuSetSubsystem) Set(cgroup string, cfg *SubsystemConfig) error {
 	if cfg.CpuSet != "" {
 		// cpuset.cpus可以指定容器使用的cpu内核
 		limitFileName := path.Join(subsystemCgroupRoot, "cpuset.cpus")
-		if err := os.WriteFile(limitFileName, []byte(cfg.MemLimit), 0644); err != nil {
+		if err := os.WriteFile(limitFileName, []byte(cfg.CpuSet), 0644); err != nil {
 			return fmt.Errorf("error set cgroup %v", err)
 		}
 	}
@@ -0,0 +1,37 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// cgroup v2的cpuset控制器
+type CpuSetV2Subsystem struct{}
+
+func (c *CpuSetV2Subsystem) Name() string {
+	return "cpuset"
+}
+
+func (c *CpuSetV2Subsystem) Set(cgroup string, cfg *SubsystemConfig) error {
+	subsystemCgroupRoot, err := getCgroupV2Path(c.Name(), cgroup, true)
+	if err != nil {
+		return err
+	}
+
+	if cfg.CpuSet != "" {
+		limitFileName := path.Join(subsystemCgroupRoot, "cpuset.cpus")
+		if err := os.WriteFile(limitFileName, []byte(cfg.CpuSet), 0644); err != nil {
+			return fmt.Errorf("error set cgroup %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *CpuSetV2Subsystem) Apply(cgroup string, pid int) error {
+	return applyCgroupV2(cgroup, pid)
+}
+
+func (c *CpuSetV2Subsystem) Remove(cgroup string) error {
+	return removeCgroupV2(cgroup)
+}
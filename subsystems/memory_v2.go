@@ -0,0 +1,38 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// cgroup v2的memory控制器
+type MemoryV2Subsystem struct{}
+
+func (mem *MemoryV2Subsystem) Name() string {
+	return "memory"
+}
+
+func (mem *MemoryV2Subsystem) Set(cgroup string, cfg *SubsystemConfig) error {
+	subsystemCgroupRoot, err := getCgroupV2Path(mem.Name(), cgroup, true)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MemLimit != "" {
+		// memory.max对应v1中的memory.limit_in_bytes
+		limitFileName := path.Join(subsystemCgroupRoot, "memory.max")
+		if err := os.WriteFile(limitFileName, []byte(cfg.MemLimit), 0644); err != nil {
+			return fmt.Errorf("error set cgroup %v", err)
+		}
+	}
+	return nil
+}
+
+func (mem *MemoryV2Subsystem) Apply(cgroup string, pid int) error {
+	return applyCgroupV2(cgroup, pid)
+}
+
+func (mem *MemoryV2Subsystem) Remove(cgroup string) error {
+	return removeCgroupV2(cgroup)
+}
@@ -30,14 +30,24 @@ type Subsystem interface {
 
 var logger *zap.Logger
 
+var SubsystemsIns []Subsystem
+
 func init() {
 	logger, _ = zap.NewProduction()
-}
-
-var SubsystemsIns = []Subsystem{
-	&MemorySubsystem{},
-	&CpuSetSubsystem{},
-	&CpuSubsystem{},
+	// 根据主机的cgroup版本选择对应的subsystem实现
+	if IsCgroupV2() {
+		SubsystemsIns = []Subsystem{
+			&MemoryV2Subsystem{},
+			&CpuSetV2Subsystem{},
+			&CpuV2Subsystem{},
+		}
+	} else {
+		SubsystemsIns = []Subsystem{
+			&MemorySubsystem{},
+			&CpuSetSubsystem{},
+			&CpuSubsystem{},
+		}
+	}
 }
 
 type CgroupManager struct {
@@ -55,7 +65,9 @@ func NewCgroupManager(path string, cfg *SubsystemConfig) *CgroupManager {
 func (s *CgroupManager) Set() error {
 	logger.Sugar().Info("Set cgroup")
 	for _, subSysIns := range SubsystemsIns {
-		subSysIns.Set(s.Path, s.Config)
+		if err := subSysIns.Set(s.Path, s.Config); err != nil {
+			logger.Sugar().Errorf("set cgroup %s of %s err %v", s.Path, subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
@@ -63,7 +75,9 @@ func (s *CgroupManager) Set() error {
 func (s *CgroupManager) Apply(pid int) error {
 	logger.Sugar().Info("Apply pid")
 	for _, subSysIns := range SubsystemsIns {
-		subSysIns.Apply(s.Path, pid)
+		if err := subSysIns.Apply(s.Path, pid); err != nil {
+			logger.Sugar().Errorf("apply cgroup %s of %s err %v", s.Path, subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
@@ -71,7 +85,9 @@ func (s *CgroupManager) Apply(pid int) error {
 func (s *CgroupManager) Destroy() error {
 	logger.Sugar().Info("destroy cgroup")
 	for _, subSysIns := range SubsystemsIns {
-		subSysIns.Remove(s.Path)
+		if err := subSysIns.Remove(s.Path); err != nil {
+			logger.Sugar().Warnf("remove cgroup %s of %s err %v", s.Path, subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
@@ -81,7 +97,7 @@ func getCgroupPath(subsystem string, cgroup string, autoCreate bool) (string, er
 	root := findCgroupMountPoint(subsystem)
 	if _, err := os.Stat(path.Join(root, cgroup)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(root, cgroup), 0755); err != nil {
+			if err := os.MkdirAll(path.Join(root, cgroup), 0755); err != nil {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
 		}